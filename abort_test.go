@@ -0,0 +1,116 @@
+package httpmetrics_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+type brokenPipeWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenPipeWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("write tcp 127.0.0.1:80: %w", syscall.EPIPE)
+}
+
+func TestCollectWriteError(t *testing.T) {
+	var collected httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, _ *http.Request) {
+			io.WriteString(w, "Hello World")
+		}),
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		collected = m
+	})
+
+	collector.ServeHTTP(brokenPipeWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.True(t, errors.Is(collected.Response.WriteError, syscall.EPIPE))
+	require.False(t, collected.Response.Complete)
+	require.False(t, collected.Request.Canceled)
+	require.True(t, collected.ClientAborted())
+}
+
+func TestCollectCanceled(t *testing.T) {
+	var collected httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}),
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		collected = m
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	require.True(t, collected.Request.Canceled)
+	require.NoError(t, collected.Response.WriteError)
+	require.True(t, collected.ClientAborted())
+}
+
+func TestCollectComplete(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		handler  http.HandlerFunc
+		complete bool
+	}{
+		{
+			name:   "without content length",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				io.WriteString(w, "Hello World")
+			},
+			complete: true,
+		},
+		{
+			name:   "matching content length",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Length", "11")
+				io.WriteString(w, "Hello World")
+			},
+			complete: true,
+		},
+		{
+			name:   "short content length",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Length", "100")
+				io.WriteString(w, "Hello World")
+			},
+			complete: false,
+		},
+		{
+			name:   "head request",
+			method: http.MethodHead,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Length", "100")
+			},
+			complete: true,
+		},
+	}
+	for _, test := range tests {
+		var collected httpmetrics.Metrics
+		collector := httpmetrics.New(httpmetrics.CollectOptions{
+			Handler: test.handler,
+		})
+		collector.Collect(func(m httpmetrics.Metrics) {
+			collected = m
+		})
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, "/", nil))
+		require.Equal(t, test.complete, collected.Response.Complete, test.name)
+		require.False(t, collected.ClientAborted(), test.name)
+	}
+}
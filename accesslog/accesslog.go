@@ -0,0 +1,247 @@
+// Package accesslog writes httpmetrics.Metrics as access log lines
+//
+// The Common and Combined formats are rendered from a template with the following directives:
+//
+//	%%         a literal percent sign
+//	%a, %h     the client ip, see Options.ClientIPHeaders
+//	%l         always "-"
+//	%u         the basic auth user, or "-"
+//	%t         the start time of the request, e.g. [10/Oct/2000:13:55:36 -0700]
+//	%r         the request line, e.g. GET /index.html?a=b HTTP/1.1
+//	%s, %>s    the status code
+//	%b         the written response body bytes, or "-" if nothing was written
+//	%B         the written response body bytes
+//	%I         the consumed request body bytes
+//	%D         the duration in microseconds
+//	%T         the duration in seconds
+//	%m         the request method
+//	%U         the request path
+//	%q         the query string including the leading "?", or an empty string
+//	%H         the request protocol
+//	%v         the request host
+//	%R         the httpmetrics.Metrics.Route
+//	%{Name}i   the request header Name
+//	%{Name}o   the response header Name
+//
+// The W3C Extended and JSON Lines formats render a list of fields:
+//
+//	date, time             the UTC start date (2006-01-02) and time (15:04:05) of the request
+//	c-ip                   the client ip
+//	cs-username            the basic auth user
+//	cs-method              the request method
+//	cs-uri                 the request uri
+//	cs-uri-stem            the request path
+//	cs-uri-query           the query string
+//	cs-version             the request protocol
+//	cs-host                the request host
+//	sc-status              the status code
+//	sc-bytes               the written response body bytes
+//	cs-bytes               the consumed request body bytes
+//	time-taken             the duration in seconds
+//	x-route                the httpmetrics.Metrics.Route
+//	cs(Name), sc(Name)     the request or response header Name
+package accesslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/talon-one/go-httpmetrics"
+)
+
+// Format is an access log format
+type Format int
+
+const (
+	// Common is the NCSA Common log format
+	Common Format = iota
+	// Combined is the NCSA Combined log format
+	Combined
+	// W3C is the W3C Extended log format
+	W3C
+	// JSON writes one JSON object per line
+	JSON
+)
+
+const (
+	// CommonTemplate is the template of the Common format
+	CommonTemplate = `%h %l %u %t "%r" %>s %b`
+	// CombinedTemplate is the template of the Combined format
+	CombinedTemplate = CommonTemplate + ` "%{Referer}i" "%{User-Agent}i"`
+)
+
+// DefaultFields are the fields of the W3C and JSON formats if no Fields are specified
+var DefaultFields = []string{"date", "time", "c-ip", "cs-method", "cs-uri-stem", "cs-uri-query", "sc-status", "sc-bytes", "time-taken", "cs(User-Agent)"}
+
+// Options controls the behavior of the Logger
+type Options struct {
+	// Format is the log format, defaults to Common
+	Format Format
+	// Template overrides the template of the Common and Combined format
+	Template string
+	// Fields are the fields of the W3C and JSON format, defaults to DefaultFields
+	Fields []string
+	// ClientIPHeaders are the headers that are checked in order to resolve the client ip (e.g. X-Forwarded-For,
+	// X-Real-Ip or Forwarded), the remote address is used if none of them is set.
+	// Only use this if the headers are set by a trusted proxy.
+	ClientIPHeaders []string
+	// ClientIP overrides the client ip resolution
+	ClientIP func(*http.Request) string
+	// Location is the time zone of the Common and Combined format, defaults to time.Local
+	Location *time.Location
+	// BufferSize enables buffered writing, Flush must be called to write the remaining buffer
+	BufferSize int
+}
+
+// Logger writes access log lines to an io.Writer
+type Logger struct {
+	options Options
+	render  renderFunc
+	fields  string
+
+	mu          sync.Mutex
+	w           io.Writer
+	buf         *bufio.Writer
+	line        []byte
+	wroteHeader bool
+	err         error
+}
+
+// New creates a new Logger, it fails if the template or a field is invalid
+func New(w io.Writer, options Options) (*Logger, error) {
+	if options.Location == nil {
+		options.Location = time.Local
+	}
+	l := &Logger{
+		options: options,
+		w:       w,
+	}
+	if options.BufferSize > 0 {
+		l.buf = bufio.NewWriterSize(w, options.BufferSize)
+		l.w = l.buf
+	}
+
+	var err error
+	switch options.Format {
+	case Common, Combined:
+		template := options.Template
+		if template == "" {
+			template = CommonTemplate
+			if options.Format == Combined {
+				template = CombinedTemplate
+			}
+		}
+		l.render, err = l.compileTemplate(template)
+	case W3C, JSON:
+		fields := options.Fields
+		if len(fields) == 0 {
+			fields = DefaultFields
+		}
+		if options.Format == W3C {
+			l.render, err = l.compileW3C(fields)
+		} else {
+			l.render, err = l.compileJSON(fields)
+		}
+	default:
+		err = fmt.Errorf("accesslog: unknown format %d", options.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Observe writes the access log line for the metrics, it can be used as a httpmetrics.MetricsFunc.
+// Errors are reported by Err and Flush.
+func (l *Logger) Observe(m httpmetrics.Metrics) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+	if l.options.Format == W3C && !l.wroteHeader {
+		l.wroteHeader = true
+		l.write([]byte("#Version: 1.0\n#Date: " + time.Now().UTC().Format("2006-01-02 15:04:05") + "\n#Fields: " + l.fields + "\n"))
+	}
+	l.line = l.render(l.line[:0], m)
+	l.line = append(l.line, '\n')
+	l.write(l.line)
+}
+
+// Flush writes the buffered lines to the underlying io.Writer
+func (l *Logger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf != nil && l.err == nil {
+		l.err = l.buf.Flush()
+	}
+	return l.err
+}
+
+// Err returns the first error that occurred while writing
+func (l *Logger) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *Logger) write(b []byte) {
+	if l.err != nil {
+		return
+	}
+	_, l.err = l.w.Write(b)
+}
+
+// clientIP resolves the client ip of the request
+func (l *Logger) clientIP(r *http.Request) string {
+	if l.options.ClientIP != nil {
+		return l.options.ClientIP(r)
+	}
+	for _, name := range l.options.ClientIPHeaders {
+		value := r.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		// X-Forwarded-For and Forwarded contain a list of proxies, the first one is the client
+		if i := strings.IndexByte(value, ','); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.TrimSpace(value)
+		if strings.EqualFold(name, "Forwarded") {
+			value = forwardedFor(value)
+		}
+		if value != "" {
+			return value
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// forwardedFor returns the for parameter of a Forwarded header element
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+			v := strings.Trim(pair[4:], `"`)
+			if strings.HasPrefix(v, "[") {
+				if i := strings.IndexByte(v, ']'); i > 0 {
+					return v[1:i]
+				}
+			}
+			if host, _, err := net.SplitHostPort(v); err == nil {
+				return host
+			}
+			return v
+		}
+	}
+	return ""
+}
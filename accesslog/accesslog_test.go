@@ -0,0 +1,176 @@
+package accesslog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+	"github.com/talon-one/go-httpmetrics/accesslog"
+)
+
+func testMetrics() httpmetrics.Metrics {
+	var m httpmetrics.Metrics
+	m.Start = time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+	m.Duration = 1500 * time.Millisecond
+	m.Route = "/items/"
+	m.Request.Request = httptest.NewRequest(http.MethodGet, "/items/apache_pb.gif?a=b", nil)
+	m.Request.RemoteAddr = "127.0.0.1:1234"
+	m.Request.SetBasicAuth("frank", "secret")
+	m.Request.Header.Set("Referer", "http://www.example.com/start.html")
+	m.Request.Header.Set("User-Agent", `Mozilla/4.08 "quoted"`)
+	m.Response.Code = http.StatusOK
+	m.Response.WrittenBodyBytes = 2326
+	m.Response.Header = http.Header{"Content-Type": {"image/gif"}}
+	return m
+}
+
+func TestCommon(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(&buf, accesslog.Options{Location: time.FixedZone("", -7*60*60)})
+	require.NoError(t, err)
+	l.Observe(testMetrics())
+
+	m := testMetrics()
+	m.Response.WrittenBodyBytes = 0
+	m.Request.Header.Del("Authorization")
+	l.Observe(m)
+	require.NoError(t, l.Err())
+	require.Equal(t, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /items/apache_pb.gif?a=b HTTP/1.1" 200 2326`+"\n"+
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /items/apache_pb.gif?a=b HTTP/1.1" 200 -`+"\n", buf.String())
+}
+
+func TestCombined(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(&buf, accesslog.Options{Format: accesslog.Combined, Location: time.UTC})
+	require.NoError(t, err)
+	l.Observe(testMetrics())
+	require.Equal(t, `127.0.0.1 - frank [10/Oct/2000:20:55:36 +0000] "GET /items/apache_pb.gif?a=b HTTP/1.1" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 \"quoted\""`+"\n", buf.String())
+}
+
+func TestTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(&buf, accesslog.Options{Template: `%m %U%q %H %v %R %s %B %I %D %T %{Content-Type}o %{X-Missing}i 100%%`})
+	require.NoError(t, err)
+	m := testMetrics()
+	m.Request.URL.Path = "/new\nline"
+	l.Observe(m)
+	require.Equal(t, `GET /new\x0aline?a=b HTTP/1.1 example.com /items/ 200 2326 0 1500000 1 image/gif - 100%`+"\n", buf.String())
+
+	for _, template := range []string{"%", "%>b", "%{Name", "%{Name}x", "%z"} {
+		_, err := accesslog.New(&buf, accesslog.Options{Template: template})
+		require.Error(t, err, template)
+	}
+}
+
+func TestW3C(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(&buf, accesslog.Options{Format: accesslog.W3C})
+	require.NoError(t, err)
+	l.Observe(testMetrics())
+	l.Observe(testMetrics())
+
+	lines := strings.Split(buf.String(), "\n")
+	require.Len(t, lines, 6)
+	require.Equal(t, "#Version: 1.0", lines[0])
+	require.True(t, strings.HasPrefix(lines[1], "#Date: "))
+	require.Equal(t, "#Fields: "+strings.Join(accesslog.DefaultFields, " "), lines[2])
+	require.Equal(t, `2000-10-10 20:55:36 127.0.0.1 GET /items/apache_pb.gif a=b 200 2326 1.500 Mozilla/4.08+\"quoted\"`, lines[3])
+	require.Equal(t, lines[3], lines[4])
+	require.Equal(t, "", lines[5])
+
+	_, err = accesslog.New(&buf, accesslog.Options{Format: accesslog.W3C, Fields: []string{"unknown"}})
+	require.Error(t, err)
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(&buf, accesslog.Options{Format: accesslog.JSON, Fields: []string{"c-ip", "cs-username", "cs-uri", "sc-status", "time-taken", "x-route", "sc(Content-Type)"}})
+	require.NoError(t, err)
+	l.Observe(testMetrics())
+	l.Observe(testMetrics())
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, map[string]interface{}{
+		"c-ip":             "127.0.0.1",
+		"cs-username":      "frank",
+		"cs-uri":           "/items/apache_pb.gif?a=b",
+		"sc-status":        float64(200),
+		"time-taken":       1.5,
+		"x-route":          "/items/",
+		"sc(Content-Type)": "image/gif",
+	}, entry)
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		header string
+		value  string
+		ip     string
+	}{
+		{"X-Forwarded-For", "10.0.0.1, 10.0.0.2", "10.0.0.1"},
+		{"X-Real-Ip", "10.0.0.3", "10.0.0.3"},
+		{"Forwarded", `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`, "2001:db8::1"},
+		{"Forwarded", "proto=https;for=10.0.0.4:80", "10.0.0.4"},
+		{"X-Other", "10.0.0.5", "127.0.0.1"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		l, err := accesslog.New(&buf, accesslog.Options{
+			Template:        "%a",
+			ClientIPHeaders: []string{"X-Forwarded-For", "X-Real-Ip", "Forwarded"},
+		})
+		require.NoError(t, err)
+		m := testMetrics()
+		m.Request.Header.Set(test.header, test.value)
+		l.Observe(m)
+		require.Equal(t, test.ip+"\n", buf.String(), test.header)
+	}
+
+	var buf bytes.Buffer
+	l, err := accesslog.New(&buf, accesslog.Options{
+		Template: "%a",
+		ClientIP: func(*http.Request) string { return "custom" },
+	})
+	require.NoError(t, err)
+	l.Observe(testMetrics())
+	require.Equal(t, "custom\n", buf.String())
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestBuffered(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(&buf, accesslog.Options{BufferSize: 4096})
+	require.NoError(t, err)
+
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}),
+	})
+	collector.Collect(l.Observe)
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Empty(t, buf.String())
+	require.NoError(t, l.Flush())
+	require.Contains(t, buf.String(), `"GET / HTTP/1.1" 200 5`)
+
+	l, err = accesslog.New(errorWriter{}, accesslog.Options{})
+	require.NoError(t, err)
+	l.Observe(testMetrics())
+	require.EqualError(t, l.Err(), "write failed")
+	require.EqualError(t, l.Flush(), "write failed")
+}
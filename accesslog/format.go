@@ -0,0 +1,326 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/talon-one/go-httpmetrics"
+)
+
+type renderFunc func(b []byte, m httpmetrics.Metrics) []byte
+
+// compileTemplate parses a template of the Common and Combined format
+func (l *Logger) compileTemplate(template string) (renderFunc, error) {
+	var segments []renderFunc
+	literal := func(s string) {
+		if s != "" {
+			segments = append(segments, func(b []byte, _ httpmetrics.Metrics) []byte {
+				return append(b, s...)
+			})
+		}
+	}
+
+	for {
+		i := strings.IndexByte(template, '%')
+		if i < 0 {
+			literal(template)
+			break
+		}
+		literal(template[:i])
+		template = template[i+1:]
+		if template == "" {
+			return nil, fmt.Errorf("accesslog: incomplete directive at the end of the template")
+		}
+
+		var name string
+		switch template[0] {
+		case '%':
+			literal("%")
+			template = template[1:]
+			continue
+		case '>':
+			// %>s is the final status which is the only status that is known
+			template = template[1:]
+			if !strings.HasPrefix(template, "s") {
+				return nil, fmt.Errorf("accesslog: %%> must be followed by s")
+			}
+		case '{':
+			end := strings.IndexByte(template, '}')
+			if end < 0 || end+1 >= len(template) {
+				return nil, fmt.Errorf("accesslog: incomplete directive %%%s", template)
+			}
+			name = template[1:end]
+			template = template[end+1:]
+		}
+
+		segment, err := l.directive(template[0], name)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+		template = template[1:]
+	}
+
+	return func(b []byte, m httpmetrics.Metrics) []byte {
+		for _, segment := range segments {
+			b = segment(b, m)
+		}
+		return b
+	}, nil
+}
+
+func (l *Logger) directive(c byte, name string) (renderFunc, error) {
+	if name != "" {
+		switch c {
+		case 'i':
+			return func(b []byte, m httpmetrics.Metrics) []byte {
+				return appendDash(b, m.Request.Header.Get(name))
+			}, nil
+		case 'o':
+			return func(b []byte, m httpmetrics.Metrics) []byte {
+				return appendDash(b, m.Response.Header.Get(name))
+			}, nil
+		}
+		return nil, fmt.Errorf("accesslog: unknown directive %%{%s}%c", name, c)
+	}
+
+	switch c {
+	case 'a', 'h':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return appendDash(b, l.clientIP(m.Request.Request))
+		}, nil
+	case 'l':
+		return func(b []byte, _ httpmetrics.Metrics) []byte {
+			return append(b, '-')
+		}, nil
+	case 'u':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return appendDash(b, user(m.Request.Request))
+		}, nil
+	case 't':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			b = append(b, '[')
+			b = m.Start.In(l.options.Location).AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+			return append(b, ']')
+		}, nil
+	case 'r':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			b = appendEscaped(b, m.Request.Method)
+			b = append(b, ' ')
+			b = appendEscaped(b, requestURI(m.Request.Request))
+			b = append(b, ' ')
+			return appendEscaped(b, m.Request.Proto)
+		}, nil
+	case 's':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return strconv.AppendInt(b, int64(m.Response.Code), 10)
+		}, nil
+	case 'b':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			if m.Response.WrittenBodyBytes == 0 {
+				return append(b, '-')
+			}
+			return strconv.AppendInt(b, int64(m.Response.WrittenBodyBytes), 10)
+		}, nil
+	case 'B':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return strconv.AppendInt(b, int64(m.Response.WrittenBodyBytes), 10)
+		}, nil
+	case 'I':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return strconv.AppendInt(b, int64(m.Request.ConsumedBodyBytes), 10)
+		}, nil
+	case 'D':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return strconv.AppendInt(b, m.Duration.Microseconds(), 10)
+		}, nil
+	case 'T':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return strconv.AppendInt(b, int64(m.Duration/time.Second), 10)
+		}, nil
+	case 'm':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return appendDash(b, m.Request.Method)
+		}, nil
+	case 'U':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return appendDash(b, m.Request.URL.Path)
+		}, nil
+	case 'q':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			if m.Request.URL.RawQuery == "" {
+				return b
+			}
+			return appendEscaped(append(b, '?'), m.Request.URL.RawQuery)
+		}, nil
+	case 'H':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return appendDash(b, m.Request.Proto)
+		}, nil
+	case 'v':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return appendDash(b, m.Request.Host)
+		}, nil
+	case 'R':
+		return func(b []byte, m httpmetrics.Metrics) []byte {
+			return appendDash(b, m.Route)
+		}, nil
+	}
+	return nil, fmt.Errorf("accesslog: unknown directive %%%c", c)
+}
+
+// appendDash appends the escaped value or a dash if the value is empty
+func appendDash(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, '-')
+	}
+	return appendEscaped(b, s)
+}
+
+// appendEscaped appends the value with quotes, backslashes and control characters escaped, so that a value can not
+// break out of a quoted string or inject new lines
+func appendEscaped(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < 0x20 || c == 0x7f:
+			b = append(b, '\\', 'x', hex[c>>4], hex[c&0xf])
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// field returns a string, int or float64 value of the W3C and JSON format
+type field func(m httpmetrics.Metrics) interface{}
+
+func (l *Logger) field(name string) (field, error) {
+	if len(name) > 4 && strings.HasSuffix(name, ")") {
+		header := name[3 : len(name)-1]
+		switch name[:3] {
+		case "cs(":
+			return func(m httpmetrics.Metrics) interface{} { return m.Request.Header.Get(header) }, nil
+		case "sc(":
+			return func(m httpmetrics.Metrics) interface{} { return m.Response.Header.Get(header) }, nil
+		}
+	}
+
+	switch name {
+	case "date":
+		return func(m httpmetrics.Metrics) interface{} { return m.Start.UTC().Format("2006-01-02") }, nil
+	case "time":
+		return func(m httpmetrics.Metrics) interface{} { return m.Start.UTC().Format("15:04:05") }, nil
+	case "c-ip":
+		return func(m httpmetrics.Metrics) interface{} { return l.clientIP(m.Request.Request) }, nil
+	case "cs-username":
+		return func(m httpmetrics.Metrics) interface{} { return user(m.Request.Request) }, nil
+	case "cs-method":
+		return func(m httpmetrics.Metrics) interface{} { return m.Request.Method }, nil
+	case "cs-uri":
+		return func(m httpmetrics.Metrics) interface{} { return requestURI(m.Request.Request) }, nil
+	case "cs-uri-stem":
+		return func(m httpmetrics.Metrics) interface{} { return m.Request.URL.Path }, nil
+	case "cs-uri-query":
+		return func(m httpmetrics.Metrics) interface{} { return m.Request.URL.RawQuery }, nil
+	case "cs-version":
+		return func(m httpmetrics.Metrics) interface{} { return m.Request.Proto }, nil
+	case "cs-host":
+		return func(m httpmetrics.Metrics) interface{} { return m.Request.Host }, nil
+	case "sc-status":
+		return func(m httpmetrics.Metrics) interface{} { return m.Response.Code }, nil
+	case "sc-bytes":
+		return func(m httpmetrics.Metrics) interface{} { return m.Response.WrittenBodyBytes }, nil
+	case "cs-bytes":
+		return func(m httpmetrics.Metrics) interface{} { return m.Request.ConsumedBodyBytes }, nil
+	case "time-taken":
+		return func(m httpmetrics.Metrics) interface{} { return m.Duration.Seconds() }, nil
+	case "x-route":
+		return func(m httpmetrics.Metrics) interface{} { return m.Route }, nil
+	}
+	return nil, fmt.Errorf("accesslog: unknown field %q", name)
+}
+
+func (l *Logger) fieldList(names []string) ([]field, error) {
+	fields := make([]field, len(names))
+	for i, name := range names {
+		f, err := l.field(name)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = f
+	}
+	return fields, nil
+}
+
+// compileW3C renders the fields separated by spaces, empty values are written as a dash and spaces are replaced
+// with a plus
+func (l *Logger) compileW3C(names []string) (renderFunc, error) {
+	fields, err := l.fieldList(names)
+	if err != nil {
+		return nil, err
+	}
+	l.fields = strings.Join(names, " ")
+	return func(b []byte, m httpmetrics.Metrics) []byte {
+		for i, f := range fields {
+			if i > 0 {
+				b = append(b, ' ')
+			}
+			switch v := f(m).(type) {
+			case int:
+				b = strconv.AppendInt(b, int64(v), 10)
+			case float64:
+				b = strconv.AppendFloat(b, v, 'f', 3, 64)
+			case string:
+				b = appendDash(b, strings.Replace(v, " ", "+", -1))
+			}
+		}
+		return b
+	}, nil
+}
+
+// compileJSON renders the fields as a JSON object keyed by the field names
+func (l *Logger) compileJSON(names []string) (renderFunc, error) {
+	fields, err := l.fieldList(names)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(names))
+	for i, name := range names {
+		key, _ := json.Marshal(name)
+		keys[i] = string(key) + ":"
+	}
+	return func(b []byte, m httpmetrics.Metrics) []byte {
+		b = append(b, '{')
+		for i, f := range fields {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, keys[i]...)
+			value, _ := json.Marshal(f(m))
+			b = append(b, value...)
+		}
+		return append(b, '}')
+	}, nil
+}
+
+func user(r *http.Request) string {
+	if u, _, ok := r.BasicAuth(); ok {
+		return u
+	}
+	return ""
+}
+
+func requestURI(r *http.Request) string {
+	if r.RequestURI != "" {
+		return r.RequestURI
+	}
+	return r.URL.RequestURI()
+}
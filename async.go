@@ -0,0 +1,176 @@
+package httpmetrics
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+)
+
+// DropPolicy controls what happens to Metrics if the async queue is full
+type DropPolicy int
+
+const (
+	// DropNewest drops the Metrics that do not fit into the queue
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest queued Metrics to make room for the new ones
+	DropOldest
+	// Block waits until there is room in the queue, this delays the response of the request
+	Block
+)
+
+// AsyncOptions controls the asynchronous delivery of Metrics to the MetricsFuncs
+type AsyncOptions struct {
+	// QueueSize is the maximum count of Metrics waiting for delivery, defaults to 1024
+	QueueSize int
+	// Workers is the count of goroutines that deliver Metrics, defaults to 1
+	Workers int
+	// DropPolicy controls what happens if the queue is full
+	DropPolicy DropPolicy
+}
+
+// Stats holds counters of a Collector
+type Stats struct {
+	// Processed is the count of Metrics that have been passed to a MetricsFunc or CustomRouter
+	Processed uint64
+	// Dropped is the count of Metrics that have been dropped because the queue was full or the Collector was closed
+	Dropped uint64
+	// Queued is the count of Metrics currently waiting for delivery
+	Queued int
+	// DegradedCaptures is the count of bodies that were not collected because the MaxBufferedBytes budget was exhausted
+	DegradedCaptures uint64
+	// ReservedBytes is the count of bytes currently reserved for body buffers
+	ReservedBytes int64
+}
+
+type delivery struct {
+	router  http.Handler
+	metrics Metrics
+	request *http.Request
+	// reserved is the count of budget bytes that are released after the delivery
+	reserved int64
+}
+
+type asyncQueue struct {
+	collector *Collector
+	policy    DropPolicy
+	queue     chan delivery
+	workers   sync.WaitGroup
+
+	// closeMu guards closed and prevents the queue from being closed while sending
+	closeMu sync.RWMutex
+	closed  bool
+
+	// mu guards pending, idle is signaled when pending drops to 0
+	mu      sync.Mutex
+	idle    *sync.Cond
+	pending int
+}
+
+func newAsyncQueue(collector *Collector, options AsyncOptions) *asyncQueue {
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1024
+	}
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	q := &asyncQueue{
+		collector: collector,
+		policy:    options.DropPolicy,
+		queue:     make(chan delivery, options.QueueSize),
+	}
+	q.idle = sync.NewCond(&q.mu)
+	q.workers.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *asyncQueue) enqueue(d delivery) {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	if q.closed {
+		q.collector.drop(d)
+		return
+	}
+
+	q.add(1)
+	switch q.policy {
+	case Block:
+		q.queue <- d
+	case DropOldest:
+		for {
+			select {
+			case q.queue <- d:
+				return
+			default:
+			}
+			select {
+			case old := <-q.queue:
+				q.collector.drop(old)
+				q.add(-1)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.queue <- d:
+		default:
+			q.collector.drop(d)
+			q.add(-1)
+		}
+	}
+}
+
+func (q *asyncQueue) add(delta int) {
+	q.mu.Lock()
+	q.pending += delta
+	if q.pending == 0 {
+		q.idle.Broadcast()
+	}
+	q.mu.Unlock()
+}
+
+func (q *asyncQueue) work() {
+	defer q.workers.Done()
+	for d := range q.queue {
+		q.process(d)
+	}
+}
+
+func (q *asyncQueue) process(d delivery) {
+	defer q.add(-1)
+	defer func() {
+		// there is no http.Server that recovers the panic for us
+		if err := recover(); err != nil {
+			log.Printf("httpmetrics: panic delivering metrics: %v\n%s", err, debug.Stack())
+		}
+	}()
+	q.collector.process(d)
+}
+
+// flush waits until all queued Metrics have been delivered
+func (q *asyncQueue) flush() {
+	q.mu.Lock()
+	for q.pending > 0 {
+		q.idle.Wait()
+	}
+	q.mu.Unlock()
+}
+
+func (q *asyncQueue) close() {
+	q.closeMu.Lock()
+	if q.closed {
+		q.closeMu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.queue)
+	q.closeMu.Unlock()
+	q.workers.Wait()
+}
+
+func (q *asyncQueue) queued() int {
+	return len(q.queue)
+}
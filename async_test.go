@@ -0,0 +1,156 @@
+package httpmetrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+func serve(collector *httpmetrics.Collector, n int) {
+	for i := 0; i < n; i++ {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+}
+
+func TestAsyncDoesNotBlockRequest(t *testing.T) {
+	release := make(chan struct{})
+	codes := make(chan int, 1)
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}),
+		Async: &httpmetrics.AsyncOptions{},
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		<-release
+		codes <- m.Response.Code
+	})
+
+	done := make(chan struct{})
+	go func() {
+		serve(collector, 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.FailNow(t, "request was blocked by the MetricsFunc")
+	}
+	close(release)
+	require.Equal(t, http.StatusAccepted, <-codes)
+	require.NoError(t, collector.Close())
+	require.Equal(t, httpmetrics.Stats{Processed: 1}, collector.Stats())
+}
+
+func TestAsyncDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy    httpmetrics.DropPolicy
+		delivered []int
+	}{
+		{httpmetrics.DropNewest, []int{0, 1, 2}},
+		{httpmetrics.DropOldest, []int{0, 3, 4}},
+	}
+	for _, test := range tests {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var delivered []int
+		i := 0
+		collector := httpmetrics.New(httpmetrics.CollectOptions{
+			Handler: HandleAllRequests(func(w http.ResponseWriter, _ *http.Request) {
+				httpmetrics.SetCustomMetric(w, "i", i)
+				i++
+			}),
+			Async: &httpmetrics.AsyncOptions{
+				QueueSize:  2,
+				DropPolicy: test.policy,
+			},
+		})
+		collector.Collect(func(m httpmetrics.Metrics) {
+			v, _ := m.GetCustomMetric("i")
+			if v.(int) == 0 {
+				close(started)
+				<-release
+			}
+			mu.Lock()
+			delivered = append(delivered, v.(int))
+			mu.Unlock()
+		})
+
+		// the first request blocks the worker
+		serve(collector, 1)
+		<-started
+		serve(collector, 4)
+		require.Equal(t, httpmetrics.Stats{Dropped: 2, Queued: 2}, collector.Stats())
+
+		close(release)
+		collector.Flush()
+		require.Equal(t, test.delivered, delivered)
+		require.Equal(t, httpmetrics.Stats{Processed: 3, Dropped: 2}, collector.Stats())
+		require.NoError(t, collector.Close())
+	}
+}
+
+func TestAsyncBlock(t *testing.T) {
+	var mu sync.Mutex
+	delivered := 0
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		Async: &httpmetrics.AsyncOptions{
+			QueueSize:  1,
+			Workers:    4,
+			DropPolicy: httpmetrics.Block,
+		},
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		delivered++
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			serve(collector, 10)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, collector.Close())
+	require.Equal(t, 100, delivered)
+	require.Equal(t, httpmetrics.Stats{Processed: 100}, collector.Stats())
+}
+
+func TestAsyncClose(t *testing.T) {
+	var mu sync.Mutex
+	delivered := 0
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		Async:   &httpmetrics.AsyncOptions{QueueSize: 100},
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		delivered++
+		mu.Unlock()
+	})
+
+	serve(collector, 10)
+	require.NoError(t, collector.Close())
+	require.Equal(t, 10, delivered)
+
+	// requests after close are still served, but their metrics are dropped
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, collector.Close())
+	require.Equal(t, 10, delivered)
+	require.Equal(t, httpmetrics.Stats{Processed: 10, Dropped: 1}, collector.Stats())
+}
@@ -0,0 +1,149 @@
+package httpmetrics_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/talon-one/go-httpmetrics"
+)
+
+// benchWriter is a http.ResponseWriter that discards everything without allocating
+type benchWriter struct {
+	header http.Header
+}
+
+func (w *benchWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *benchWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *benchWriter) WriteHeader(int) {}
+
+// benchBody is a reusable request body
+type benchBody struct {
+	strings.Reader
+}
+
+func (*benchBody) Close() error {
+	return nil
+}
+
+var benchPayload = strings.Repeat("x", 512)
+
+type customMetricKey struct{}
+
+func benchmarkCollector(b *testing.B, options httpmetrics.CollectOptions, fn httpmetrics.MetricsFunc, body bool) {
+	collector := httpmetrics.New(options)
+	collector.Collect(fn)
+
+	w := &benchWriter{header: make(http.Header)}
+	req := httptest.NewRequest(http.MethodPost, "/bench", nil)
+	var reqBody benchBody
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if body {
+			reqBody.Reset(benchPayload)
+			req.Body = &reqBody
+		} else {
+			req.Body = http.NoBody
+		}
+		collector.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkCollectNoBody(b *testing.B) {
+	benchmarkCollector(b, httpmetrics.CollectOptions{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "Hello World")
+		}),
+	}, func(httpmetrics.Metrics) {}, false)
+}
+
+func BenchmarkCollectBody(b *testing.B) {
+	var buf [1024]byte
+	benchmarkCollector(b, httpmetrics.CollectOptions{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n, _ := io.ReadFull(r.Body, buf[:512])
+			w.Write(buf[:n])
+		}),
+		CollectRequestBody:  1024,
+		CollectResponseBody: 1024,
+	}, func(httpmetrics.Metrics) {}, true)
+}
+
+func BenchmarkCollectCustomMetric(b *testing.B) {
+	benchmarkCollector(b, httpmetrics.CollectOptions{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httpmetrics.SetCustomMetric(w, customMetricKey{}, "value")
+			io.WriteString(w, "Hello World")
+		}),
+	}, func(m httpmetrics.Metrics) {
+		if _, ok := m.GetCustomMetric(customMetricKey{}); !ok {
+			panic("custom metric is missing")
+		}
+	}, false)
+}
+
+func BenchmarkHandlerWithoutCollector(b *testing.B) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		io.WriteString(w, "Hello World")
+	})
+	w := &benchWriter{header: make(http.Header)}
+	req := httptest.NewRequest(http.MethodPost, "/bench", nil)
+	var reqBody benchBody
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reqBody.Reset(benchPayload)
+		req.Body = &reqBody
+		handler.ServeHTTP(w, req)
+	}
+}
+
+func benchmarkCollectorParallel(b *testing.B, options httpmetrics.CollectOptions, paths ...string) {
+	options.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Hello World")
+	})
+	collector := httpmetrics.New(options)
+	collector.Collect(func(httpmetrics.Metrics) {}, paths...)
+
+	b.ReportAllocs()
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		w := &benchWriter{header: make(http.Header)}
+		req := httptest.NewRequest(http.MethodGet, "/bench", nil)
+		for pb.Next() {
+			collector.ServeHTTP(w, req)
+		}
+	})
+}
+
+func BenchmarkCollectParallel(b *testing.B) {
+	benchmarkCollectorParallel(b, httpmetrics.CollectOptions{}, "/bench", "/users/{id}", "/static/")
+}
+
+func BenchmarkCollectParallelSlowCustomRouter(b *testing.B) {
+	benchmarkCollectorParallel(b, httpmetrics.CollectOptions{
+		CustomRouter: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if req, ok := w.(*httpmetrics.MetricsRequest); ok {
+				// e.g. a lookup of the route in a remote config
+				time.Sleep(100 * time.Microsecond)
+				req.Collect = true
+				req.Route = "bench"
+			}
+		}),
+	}, "/users/{id}")
+}
@@ -0,0 +1,61 @@
+package httpmetrics
+
+import (
+	"sync/atomic"
+)
+
+// byteBudget limits the bytes reserved for body buffers across all requests
+type byteBudget struct {
+	limit    int64
+	reserved atomic.Int64
+}
+
+func (budget *byteBudget) reserve(n int64) bool {
+	for {
+		current := budget.reserved.Load()
+		if current+n > budget.limit {
+			return false
+		}
+		if budget.reserved.CompareAndSwap(current, current+n) {
+			return true
+		}
+	}
+}
+
+func (budget *byteBudget) release(n int64) {
+	budget.reserved.Add(-n)
+}
+
+// reserveBodies reserves the body buffers of a request, if a buffer cannot be reserved it returns a copy of the
+// options with the collection of the body disabled
+func (collector *Collector) reserveBodies(options *CollectOptions) (*CollectOptions, int64) {
+	if collector.budget == nil {
+		return options, 0
+	}
+	var reserved int64
+	requestBody, responseBody := options.CollectRequestBody, options.CollectResponseBody
+	for _, size := range []*int{&requestBody, &responseBody} {
+		if *size <= 0 {
+			continue
+		}
+		if collector.budget.reserve(int64(*size)) {
+			reserved += int64(*size)
+		} else {
+			*size = 0
+			collector.degraded.Add(1)
+		}
+	}
+	if requestBody != options.CollectRequestBody || responseBody != options.CollectResponseBody {
+		// the options are shared with other requests
+		degraded := *options
+		degraded.CollectRequestBody, degraded.CollectResponseBody = requestBody, responseBody
+		options = &degraded
+	}
+	return options, reserved
+}
+
+func (collector *Collector) releaseBodies(reserved int64) {
+	if reserved > 0 {
+		collector.budget.release(reserved)
+	}
+}
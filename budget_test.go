@@ -0,0 +1,97 @@
+package httpmetrics_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+func TestMaxBufferedBytes(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.URL.Path == "/block" {
+				close(started)
+				<-unblock
+			}
+			w.Write(body)
+		}),
+		CollectRequestBody:  600,
+		CollectResponseBody: 600,
+		MaxBufferedBytes:    1500,
+	})
+
+	var mu sync.Mutex
+	metrics := make(map[string]httpmetrics.Metrics)
+	var reserved []int64
+	collector.Collect(func(m httpmetrics.Metrics) {
+		mu.Lock()
+		defer mu.Unlock()
+		metrics[m.Request.URL.Path] = m.Clone()
+		reserved = append(reserved, collector.Stats().ReservedBytes)
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/block", strings.NewReader("first")))
+	}()
+	<-started
+	require.Equal(t, int64(1200), collector.Stats().ReservedBytes)
+
+	// only 300 bytes are left, so both bodies of a concurrent request are degraded to size-only
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/concurrent", strings.NewReader("second")))
+	close(unblock)
+	wg.Wait()
+
+	require.Equal(t, "first", string(metrics["/block"].Request.Body))
+	require.Equal(t, "first", string(metrics["/block"].Response.Body))
+	require.Empty(t, metrics["/concurrent"].Request.Body)
+	require.Empty(t, metrics["/concurrent"].Response.Body)
+	require.Equal(t, 6, metrics["/concurrent"].Request.ConsumedBodyBytes)
+	require.Equal(t, 6, metrics["/concurrent"].Response.WrittenBodyBytes)
+	require.Equal(t, []int64{1200, 1200}, reserved)
+
+	stats := collector.Stats()
+	require.Equal(t, uint64(2), stats.DegradedCaptures)
+	require.Equal(t, int64(0), stats.ReservedBytes)
+
+	// the reservation is released if the handler panics
+	collector.Options.Handler = HandleAllRequests(func(http.ResponseWriter, *http.Request) {
+		panic("test")
+	})
+	require.Panics(t, func() {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	require.Equal(t, int64(0), collector.Stats().ReservedBytes)
+}
+
+func TestMaxBufferedBytesAsync(t *testing.T) {
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler:             HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		CollectResponseBody: 100,
+		MaxBufferedBytes:    1000,
+		Async:               &httpmetrics.AsyncOptions{QueueSize: 1},
+	})
+	unblock := make(chan struct{})
+	collector.Collect(func(httpmetrics.Metrics) {
+		<-unblock
+	})
+	for i := 0; i < 5; i++ {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	// at most one delivery is processed and one queued, the dropped ones released their reservation
+	require.True(t, collector.Stats().ReservedBytes <= 200)
+	close(unblock)
+	require.NoError(t, collector.Close())
+	require.Equal(t, int64(0), collector.Stats().ReservedBytes)
+}
@@ -2,10 +2,9 @@ package httpmetrics
 
 import (
 	"net/http"
-	"path"
-	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/talon-one/go-httpmetrics/internal"
@@ -15,9 +14,18 @@ import (
 type Collector struct {
 	Options *CollectOptions
 
-	mu             sync.Mutex
-	routes         map[string]http.HandlerFunc
-	defaultHandler http.HandlerFunc
+	// mu serializes the changes of the routing table, requests only load the current routing table
+	mu      sync.Mutex
+	routing atomic.Pointer[routingTable]
+
+	queue     *asyncQueue
+	processed atomic.Uint64
+	dropped   atomic.Uint64
+
+	budget   *byteBudget
+	degraded atomic.Uint64
+
+	caseInsensitivePaths bool
 }
 
 // CollectOptions controls the behavior of Collect
@@ -28,8 +36,52 @@ type CollectOptions struct {
 	CollectResponseBody int
 	// CollectRequestBody sets the MaxBufferSize of the Body that should be collected
 	CollectRequestBody int
-	// CustomRouter can be used to define a custom router that should be used in addition to the Collect function
+	// CaptureMode selects whether the head, the tail or both of the request and response bodies are collected
+	CaptureMode CaptureMode
+	// DrainRequestBody reads up to the specified count of request body bytes that the Handler did not read after it
+	// returned, so the size of the whole body is known in Request.TotalBodyBytes. The drained bytes are collected like
+	// the bytes the Handler read, so the tail CaptureModes collect the end of the body.
+	DrainRequestBody int
+	// ReadBodyTimeout bounds the time spent reading the request body after the Handler returned, to collect a body
+	// the Handler did not read or to drain it. 0 means no timeout. It is set as read deadline with
+	// http.ResponseController, so it only applies if the http.ResponseWriter supports SetReadDeadline, otherwise the
+	// body is read without a timeout.
+	ReadBodyTimeout time.Duration
+	// CollectRequestHeaders limits the collected request headers to the listed headers, by default all are collected
+	CollectRequestHeaders []string
+	// CollectResponseHeaders limits the collected response headers to the listed headers, by default all are collected
+	CollectResponseHeaders []string
+	// SampleRate is the fraction of requests that are collected, e.g. 0.1 for every tenth request.
+	// 0 collects all requests. It is ignored if a Sampler is set.
+	SampleRate float64
+	// Sampler decides which requests are collected, see Probability, RateLimit, HeaderHash and AlwaysSampleErrors
+	Sampler Sampler
+	// RetainBodies is called after the response was written and decides whether the collected bodies are passed
+	// with the Metrics, e.g. to keep them only for failed or slow requests.
+	// If it returns false the bodies are dropped and their buffers are reused for other requests.
+	RetainBodies RetainFunc
+	// IncludeDefault passes the Metrics of requests that matched a path or the CustomRouter also to the
+	// MetricsFuncs that were registered for all unmatched requests
+	IncludeDefault bool
+	// CustomRouter can be used to define a custom router that should be used in addition to the Collect function,
+	// it is called concurrently by all requests that did not match a path
 	CustomRouter http.Handler
+	// Redaction masks sensitive headers and body fields before the Metrics are delivered
+	Redaction *Redaction
+	// OnPanic controls whether panics of the Handler are recovered, by default panics are not intercepted
+	// and no Metrics are collected for the request
+	OnPanic PanicMode
+	// Async enables asynchronous delivery of the Metrics after the request has been served,
+	// if nil the Metrics are delivered synchronously before the handler returns.
+	// It is only used by New and cannot be changed by a CustomRouter.
+	Async *AsyncOptions
+	// MaxBufferedBytes limits the bytes that are reserved for the body buffers of all requests until their Metrics
+	// have been delivered. A body whose buffer cannot be reserved is not collected, only its size is.
+	// 0 means no limit. It is only used by New and cannot be changed by a CustomRouter.
+	MaxBufferedBytes int64
+	// CaseInsensitivePaths matches the paths of Collect case insensitively like previous versions did, the values of
+	// wildcards are lowercased as well. It is only used by New and cannot be changed by a CustomRouter.
+	CaseInsensitivePaths bool
 }
 
 // New create a new Collector
@@ -38,101 +90,336 @@ func New(options CollectOptions) *Collector {
 		options.Handler = http.DefaultServeMux
 	}
 	opts := &options
-	return &Collector{
-		routes:  make(map[string]http.HandlerFunc),
-		Options: opts,
+	collector := &Collector{
+		Options:              opts,
+		caseInsensitivePaths: options.CaseInsensitivePaths,
+	}
+	collector.routing.Store(&routingTable{})
+	if options.Async != nil {
+		collector.queue = newAsyncQueue(collector, *options.Async)
+	}
+	if options.MaxBufferedBytes > 0 {
+		collector.budget = &byteBudget{limit: options.MaxBufferedBytes}
 	}
+	return collector
 }
 
 func (collector *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if router, options := collector.shouldCollect(r); router != nil && options != nil {
+	router, options, match := collector.shouldCollect(r)
+	var sampling Decision
+	var responseSampler ResponseSampler
+	if router != nil && options != nil {
+		sampling, responseSampler = options.sample(r, match.route)
+	}
+	if router != nil && options != nil && (sampling.Sample || responseSampler != nil) {
 		var metrics Metrics
 		metrics.Request.Request = r
+		metrics.Route = match.route
+		metrics.Pattern = match.pattern
+		metrics.PathValues = match.pathValues
 
+		var reserved int64
+		options, reserved = collector.reserveBodies(options)
+		if reserved > 0 {
+			// release the reservation if the Metrics are not delivered, e.g. because the handler panicked
+			defer func() {
+				collector.releaseBodies(reserved)
+			}()
+		}
 		if options.CollectResponseBody > 0 {
-			metrics.responseWriter = internal.NewResponseWriterWithBody(w, options.CollectResponseBody)
+			metrics.responseWriter = internal.NewResponseWriterWithBody(w, options.CollectResponseBody, internal.CaptureMode(options.CaptureMode))
 		} else {
 			metrics.responseWriter = internal.NewResponseWriterWithoutBody(w)
 		}
+		metrics.generation = metrics.responseWriter.Generation()
 
-		reqBodyReader := internal.NewRequestBodyReader(r.Body, options.CollectRequestBody)
+		body := r.Body
+		reqBodyReader := internal.NewRequestBodyReader(body, options.CollectRequestBody, internal.CaptureMode(options.CaptureMode))
+		metrics.requestBody = reqBodyReader
 		r.Body = reqBodyReader
 
-		start := time.Now()
-		options.Handler.ServeHTTP(metrics.responseWriter, r)
-		metrics.Duration = time.Since(start)
+		metrics.Start = time.Now()
+		serveHandler(options, &metrics, r)
+		metrics.Request.Canceled = r.Context().Err() != nil
+		if metrics.Panic != nil && !repanic(options, &metrics) &&
+			!metrics.responseWriter.HeaderWritten() && !metrics.responseWriter.Hijacked() {
+			metrics.responseWriter.WriteHeader(http.StatusInternalServerError)
+		}
+		metrics.Duration = time.Since(metrics.Start)
+		if t := metrics.responseWriter.HeaderWrittenAt(); !t.IsZero() {
+			metrics.TimeToHeader = t.Sub(metrics.Start)
+		}
+		if t := metrics.responseWriter.FirstByteWrittenAt(); !t.IsZero() {
+			metrics.TimeToFirstByte = t.Sub(metrics.Start)
+		}
 
 		metrics.Response.Header = metrics.responseWriter.Header()
 		metrics.Response.Body = metrics.responseWriter.Body()
 		metrics.Response.Code = metrics.responseWriter.StatusCode()
+		metrics.Response.HeaderWritten = metrics.responseWriter.HeaderWritten()
+		metrics.Response.SuperfluousWriteHeaders = metrics.responseWriter.SuperfluousWriteHeaders()
+		metrics.Response.Hijacked = metrics.responseWriter.Hijacked()
+		if metrics.Panic != nil && !metrics.Response.HeaderWritten {
+			// the http.Server aborts the response
+			metrics.Response.Code = http.StatusInternalServerError
+		}
 		metrics.Response.WrittenBodyBytes = metrics.responseWriter.WrittenBodyBytes()
-		metrics.Request.Body, _ = reqBodyReader.Body()
-		metrics.Request.ConsumedBodyBytes = reqBodyReader.ConsumedBodyBytes()
+		metrics.Response.WriteError = metrics.responseWriter.WriteError()
+		metrics.Response.Truncated = metrics.responseWriter.BodyTruncated()
+		metrics.Response.ContentLength = contentLength(metrics.Response.Header)
+		metrics.Response.Complete = metrics.Response.WriteError == nil && metrics.Panic == nil && !metrics.Response.Hijacked
+		if n := metrics.Response.ContentLength; n >= 0 && int64(metrics.Response.WrittenBodyBytes) != n && r.Method != http.MethodHead {
+			metrics.Response.Complete = false
+		}
+		readBody(&metrics, reqBodyReader, r, options)
+		// the reader is released with the Metrics, so the request must not refer to it anymore
+		r.Body = body
+
+		metrics.Sampling = sampling
+		if responseSampler != nil {
+			metrics.Sampling = responseSampler.SampleResponse(metrics, sampling)
+		}
+		if !metrics.Sampling.Sample {
+			metrics.release()
+			if repanic(options, &metrics) {
+				panic(metrics.Panic)
+			}
+			return
+		}
+
+		if options.RetainBodies != nil && !options.RetainBodies(metrics) {
+			metrics.Request.Body = nil
+			metrics.Response.Body = nil
+			reqBodyReader.ReleaseBody()
+			metrics.responseWriter.ReleaseBody()
+			collector.releaseBodies(reserved)
+			reserved = 0
+		}
+
+		if len(options.CollectRequestHeaders) > 0 {
+			req := *metrics.Request.Request
+			req.Header = filterHeader(req.Header, options.CollectRequestHeaders)
+			metrics.Request.Request = &req
+		}
+		if len(options.CollectResponseHeaders) > 0 {
+			metrics.Response.Header = filterHeader(metrics.Response.Header, options.CollectResponseHeaders)
+		}
 
-		router.ServeHTTP(metrics, fakeRequest(r))
+		if options.Redaction != nil {
+			options.Redaction.apply(&metrics)
+		}
+
+		d := delivery{
+			router:   router,
+			metrics:  metrics,
+			request:  fakeRequest(metrics.Request.Request),
+			reserved: reserved,
+		}
+		// the reservation is released once the Metrics were delivered
+		reserved = 0
+		collector.deliver(d)
 
+		if repanic(options, &metrics) {
+			panic(metrics.Panic)
+		}
 		return
 	}
 	collector.Options.Handler.ServeHTTP(w, r)
 }
 
-func (collector *Collector) shouldCollect(r *http.Request) (http.Handler, *CollectOptions) {
-	if r == nil || r.URL == nil {
-		return nil, nil
+// readBody collects the request body after the Handler returned,
+// the part of the body the Handler did not read is collected or drained within the ReadBodyTimeout
+func readBody(metrics *Metrics, reqBodyReader *internal.RequestBodyReader, r *http.Request, options *CollectOptions) {
+	if options.ReadBodyTimeout > 0 && !reqBodyReader.EOF() && !metrics.Response.Hijacked {
+		rc := http.NewResponseController(metrics.responseWriter)
+		if rc.SetReadDeadline(time.Now().Add(options.ReadBodyTimeout)) == nil {
+			defer rc.SetReadDeadline(time.Time{})
+		}
+	}
+
+	// a client that expects 100 Continue might only send the body if the handler reads it
+	if !strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		reqBodyReader.Capture()
+		if options.DrainRequestBody > 0 {
+			_ = reqBodyReader.Drain(options.DrainRequestBody)
+		}
 	}
-	options := *collector.Options
-	req := MetricsRequest{
-		CollectOptions: &options,
+	// the drained bytes pass through the buffer, so the body is taken afterwards to include a drained tail
+	metrics.Request.Body, _ = reqBodyReader.Body()
+	metrics.Request.ConsumedBodyBytes = reqBodyReader.ConsumedBodyBytes()
+	metrics.Request.TotalBodyBytes = reqBodyReader.TotalBodyBytes()
+	metrics.Request.Truncated = reqBodyReader.Truncated()
+	metrics.Request.ReadError = reqBodyReader.ReadError()
+}
+
+func (collector *Collector) deliver(d delivery) {
+	if collector.queue != nil {
+		collector.queue.enqueue(d)
+		return
 	}
+	collector.process(d)
+}
+
+func (collector *Collector) process(d delivery) {
+	defer collector.releaseBodies(d.reserved)
+	defer d.metrics.release()
+	defer collector.processed.Add(1)
+	d.router.ServeHTTP(d.metrics, d.request)
+}
+
+// drop counts a delivery that was not processed
+func (collector *Collector) drop(d delivery) {
+	collector.dropped.Add(1)
+	d.metrics.release()
+	collector.releaseBodies(d.reserved)
+}
+
+// Flush waits until all asynchronously queued Metrics have been delivered
+func (collector *Collector) Flush() {
+	if collector.queue != nil {
+		collector.queue.flush()
+	}
+}
+
+// Close delivers all asynchronously queued Metrics and stops the delivery workers,
+// Metrics of requests served after Close are dropped. Close does nothing if Async is not enabled.
+func (collector *Collector) Close() error {
+	if collector.queue != nil {
+		collector.queue.close()
+	}
+	return nil
+}
+
+// Stats returns the current counters of the Collector
+func (collector *Collector) Stats() Stats {
+	stats := Stats{
+		Processed:        collector.processed.Load(),
+		Dropped:          collector.dropped.Load(),
+		DegradedCaptures: collector.degraded.Load(),
+	}
+	if collector.budget != nil {
+		stats.ReservedBytes = collector.budget.reserved.Load()
+	}
+	if collector.queue != nil {
+		stats.Queued = collector.queue.queued()
+	}
+	return stats
+}
+
+func (collector *Collector) shouldCollect(r *http.Request) (http.Handler, *CollectOptions, routeMatch) {
+	var match routeMatch
+	if r == nil || r.URL == nil {
+		return nil, nil, match
+	}
+	options := collector.Options
+	routing := collector.routing.Load()
 
 	// check if handled by our "internal" router
-	collector.mu.Lock()
-	handler, ok := collector.routes[strings.ToLower(r.URL.Path)]
-	if ok {
-		collector.mu.Unlock()
-		return handler, &options
+	if routing.mux != nil {
+		req := r
+		if collector.caseInsensitivePaths {
+			req = lowerPath(r)
+		}
+		if m, ok := matchRoute(routing.mux, req); ok {
+			merged := options.merge(m.registrations)
+			return includeDefault(m.handler, merged, routing.defaultHandler), merged, m
+		}
 	}
 
 	// we have no route in our router
 	// maybe the custom router has something?
-	if collector.Options.CustomRouter != nil {
-		collector.Options.CustomRouter.ServeHTTP(&req, fakeRequest(r))
+	if options.CustomRouter != nil {
+		// the CustomRouter can modify the options, so pass a copy
+		custom := *options
+		req := MetricsRequest{
+			CollectOptions: &custom,
+		}
+		options.CustomRouter.ServeHTTP(&req, fakeRequest(r))
 		if req.Collect {
-			collector.mu.Unlock()
-			return collector.Options.CustomRouter, &options
+			match.route = req.Route
+			return includeDefault(options.CustomRouter, &custom, routing.defaultHandler), &custom, match
 		}
 	}
-	// if we have a defaultHandler set
-	if collector.defaultHandler != nil {
-		collector.mu.Unlock()
-		return collector.defaultHandler, collector.Options
+	// if we have a default route set
+	if routing.defaultHandler != nil {
+		match.route = "*"
+		return routing.defaultHandler, options.merge(routing.defaultRoutes), match
 	}
-	collector.mu.Unlock()
-	return nil, nil
+	return nil, nil, match
+}
+
+// includeDefault appends the default handler if the options include the default MetricsFuncs
+func includeDefault(handler http.Handler, options *CollectOptions, defaultHandler http.Handler) http.Handler {
+	if !options.IncludeDefault || defaultHandler == nil {
+		return handler
+	}
+	return handlers{handler, defaultHandler}
 }
 
 // Collect adds the specified paths to the desired metrics function
 // if no path (or *) is specified the function will be used for all unmatched requests
-func (collector *Collector) Collect(fn MetricsFunc, paths ...string) {
-	handler := collector.routerHandler(fn)
+//
+// Paths are http.ServeMux patterns ("[METHOD ][HOST]/[PATH]") and follow the same matching and precedence rules,
+// e.g. "/users/{id}", "GET /files/{path...}" or "/static/" for a whole subtree.
+// A missing leading slash is added, "users/list" is "/users/list", so a host must contain a dot or a port.
+// Unlike in previous versions paths are matched case sensitively, "/Test" no longer matches a request for "/test",
+// unless CollectOptions.CaseInsensitivePaths is set, and a path with a trailing slash matches its whole subtree
+// instead of the exact path.
+// Like http.ServeMux.Handle, Collect panics if a pattern is invalid or conflicts with another registered pattern.
+//
+// Multiple functions can be registered for the same path, they are called in registration order.
+// The returned Registration can be used to remove the function again.
+func (collector *Collector) Collect(fn MetricsFunc, paths ...string) *Registration {
+	return collector.collect(&Registration{handler: collector.routerHandler(fn)}, paths)
+}
 
-	collector.mu.Lock()
+// CollectWithOptions works like Collect, but uses the options for requests of the specified paths.
+// The non-zero fields of options override the Collector.Options, a negative CollectRequestBody or
+// CollectResponseBody disables the collection of the body. Handler, CustomRouter and Async are ignored.
+// If multiple registrations of a path have options, they are applied in registration order.
+func (collector *Collector) CollectWithOptions(fn MetricsFunc, options CollectOptions, paths ...string) *Registration {
+	return collector.collect(&Registration{handler: collector.routerHandler(fn), options: &options}, paths)
+}
+
+func (collector *Collector) collect(registration *Registration, paths []string) *Registration {
+	registration.collector = collector
 	if len(paths) == 0 {
-		collector.defaultHandler = handler
-		collector.mu.Unlock()
-		return
+		paths = []string{"*"}
 	}
 	for _, p := range paths {
-		p = strings.ToLower(path.Clean(filepath.ToSlash(p)))
+		p = cleanPattern(p)
+		if collector.caseInsensitivePaths {
+			p = lowerPattern(p)
+		}
+		if !containsString(registration.patterns, p) {
+			registration.patterns = append(registration.patterns, p)
+		}
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	routing := collector.routing.Load()
+	routes := make(map[string][]*Registration, len(routing.routes)+len(paths))
+	for p, registrations := range routing.routes {
+		routes[p] = registrations
+	}
+	defaultRoutes := routing.defaultRoutes
+	for _, p := range registration.patterns {
+		// copy the slices on append, they are shared with the current routing table
 		if p == "*" {
-			collector.defaultHandler = handler
+			defaultRoutes = append(defaultRoutes[:len(defaultRoutes):len(defaultRoutes)], registration)
 		} else {
-			// prepend slash
-			p = "/" + strings.Trim(p, "/")
-			collector.routes[p] = handler
+			routes[p] = append(routes[p][:len(routes[p]):len(routes[p])], registration)
 		}
 	}
-	collector.mu.Unlock()
+	collector.setRoutes(routes, defaultRoutes)
+	return registration
+}
+
+// setRoutes publishes a new routing table, it must be called with mu locked
+func (collector *Collector) setRoutes(routes map[string][]*Registration, defaultRoutes []*Registration) {
+	// a panic on an invalid pattern leaves the current routing table untouched
+	collector.routing.Store(newRoutingTable(routes, defaultRoutes))
 }
 
 func (collector *Collector) routerHandler(fn MetricsFunc) func(http.ResponseWriter, *http.Request) {
@@ -4,12 +4,17 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/talon-one/go-httpmetrics"
@@ -276,6 +281,40 @@ func TestNoCollectWithCustomRouter(t *testing.T) {
 	wg.Wait()
 }
 
+func TestSlowCustomRouter(t *testing.T) {
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		CustomRouter: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			if m, ok := w.(*httpmetrics.MetricsRequest); ok && r.URL.Path == "/slow" {
+				close(entered)
+				<-unblock
+				m.Collect = true
+			}
+		}),
+	})
+	var fast []string
+	collector.Collect(func(m httpmetrics.Metrics) {
+		fast = append(fast, m.Route)
+	}, "/fast")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-entered
+
+	// neither requests nor registrations wait for the blocked CustomRouter
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	collector.Collect(func(httpmetrics.Metrics) {}, "/other").Remove()
+	require.Equal(t, []string{"/fast"}, fast)
+
+	close(unblock)
+	<-done
+}
+
 func TestCustomRouterInvalidMetricsHandler(t *testing.T) {
 	request := RequestPayload{
 		Method: http.MethodPost,
@@ -571,3 +610,522 @@ func TestCustomMetrics(t *testing.T) {
 	DoRequest(t, s, request)
 	wg.Wait()
 }
+
+func TestCustomMetricsAfterDelivery(t *testing.T) {
+	var kept []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			httpmetrics.SetCustomMetric(w, "path", r.URL.Path)
+		}),
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		v, ok := m.GetCustomMetric("path")
+		require.True(t, ok)
+		require.Equal(t, m.Request.URL.Path, v)
+		kept = append(kept, m)
+	})
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/first", nil))
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/second", nil))
+	require.Len(t, kept, 2)
+
+	// the writer of the first request might be reused by the second one, its values must not leak
+	for _, m := range kept {
+		v, ok := m.GetCustomMetric("path")
+		require.False(t, ok, "%v", v)
+	}
+}
+
+func TestCollectPattern(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+	})
+
+	collector.Collect(func(m httpmetrics.Metrics) {
+		require.FailNow(t, "this should not be called")
+	}, "/users/me")
+	// paths are matched case sensitively
+	collector.Collect(func(m httpmetrics.Metrics) {
+		require.FailNow(t, "this should not be called")
+	}, "/Users/{id}/{rest...}")
+	collector.Collect(func(m httpmetrics.Metrics) {
+		require.Equal(t, "GET /users/{id}/{rest...}", m.Route)
+		require.Equal(t, "GET /users/{id}/{rest...}", m.Pattern)
+		require.Equal(t, "42", m.PathValue("id"))
+		require.Equal(t, "posts/1", m.PathValue("rest"))
+		require.Equal(t, "", m.PathValue("unknown"))
+		wg.Done()
+	}, "GET /users/{id}/{rest...}")
+	s := httptest.NewServer(collector)
+
+	res, err := s.Client().Get(s.URL + "/users/42/posts/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	wg.Wait()
+}
+
+func TestCollectCaseInsensitivePaths(t *testing.T) {
+	var metrics []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler:              HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		CaseInsensitivePaths: true,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	}, "/Test", "GET /Users/{ID}")
+
+	for _, path := range []string{"/test", "/TEST", "/USERS/Bob", "/other"} {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	require.Len(t, metrics, 3)
+	require.Equal(t, "/test", metrics[0].Route)
+	require.Equal(t, "/test", metrics[1].Route)
+	// the handler gets the original path
+	require.Equal(t, "/TEST", metrics[1].Request.URL.Path)
+	require.Equal(t, "GET /users/{ID}", metrics[2].Route)
+	require.Equal(t, "bob", metrics[2].PathValue("ID"))
+}
+
+func TestCollectPreservesResponseWriterInterfaces(t *testing.T) {
+	for _, collectResponseBody := range []int{0, 1024} {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		collector := httpmetrics.New(httpmetrics.CollectOptions{
+			Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+				_, ok := w.(http.Flusher)
+				require.True(t, ok)
+				_, ok = w.(http.Hijacker)
+				require.True(t, ok)
+				_, ok = w.(io.ReaderFrom)
+				require.True(t, ok)
+				_, ok = w.(http.Pusher)
+				require.False(t, ok)
+
+				io.WriteString(w, "Hello")
+				require.NoError(t, http.NewResponseController(w).Flush())
+				io.WriteString(w, " World")
+			}),
+			CollectResponseBody: collectResponseBody,
+		})
+		collector.Collect(func(m httpmetrics.Metrics) {
+			require.Equal(t, 11, m.Response.WrittenBodyBytes)
+			wg.Done()
+		})
+		s := httptest.NewServer(collector)
+
+		res, err := s.Client().Get(s.URL)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(b))
+		wg.Wait()
+		s.Close()
+	}
+}
+
+func TestCollectImplicitStatusCode(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "Hello World")
+		}),
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		require.Equal(t, http.StatusOK, m.Response.Code)
+		require.True(t, m.Response.HeaderWritten)
+		require.Equal(t, 0, m.Response.SuperfluousWriteHeaders)
+		wg.Done()
+	})
+	s := httptest.NewServer(collector)
+
+	res, err := s.Client().Get(s.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	wg.Wait()
+}
+
+func TestCollectTimings(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(10 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+			time.Sleep(10 * time.Millisecond)
+			io.WriteString(w, "Hello")
+			time.Sleep(10 * time.Millisecond)
+		}),
+	})
+	before := time.Now()
+	collector.Collect(func(m httpmetrics.Metrics) {
+		require.False(t, m.Start.Before(before))
+		require.True(t, m.TimeToHeader >= 10*time.Millisecond)
+		require.True(t, m.TimeToFirstByte >= m.TimeToHeader+10*time.Millisecond)
+		require.True(t, m.Duration >= m.TimeToFirstByte+10*time.Millisecond)
+		wg.Done()
+	})
+	s := httptest.NewServer(collector)
+
+	res, err := s.Client().Get(s.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	wg.Wait()
+}
+
+func TestCollectWithOptions(t *testing.T) {
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("X-Request-Id", "1")
+			w.Write(body)
+		}),
+		CollectRequestBody:  4,
+		CollectResponseBody: 4,
+	})
+
+	var metrics []httpmetrics.Metrics
+	collect := func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	}
+	collector.CollectWithOptions(collect, httpmetrics.CollectOptions{
+		CollectRequestBody:     64 * 1024,
+		CollectResponseBody:    64 * 1024,
+		CollectRequestHeaders:  []string{"content-type"},
+		CollectResponseHeaders: []string{"X-Request-Id"},
+	}, "/webhooks")
+	collector.CollectWithOptions(collect, httpmetrics.CollectOptions{
+		CollectRequestBody:  -1,
+		CollectResponseBody: -1,
+	}, "/healthz")
+	collector.CollectWithOptions(collect, httpmetrics.CollectOptions{
+		SampleRate: 1e-12,
+	}, "/sampled")
+	collector.Collect(collect)
+
+	for _, path := range []string{"/webhooks", "/healthz", "/other"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("hello world"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("User-Agent", "test")
+		collector.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, "test", req.Header.Get("User-Agent"))
+	}
+	for i := 0; i < 100; i++ {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sampled", nil))
+	}
+
+	require.Len(t, metrics, 3)
+	require.Equal(t, "hello world", string(metrics[0].Request.Body))
+	require.Equal(t, "hello world", string(metrics[0].Response.Body))
+	require.Equal(t, http.Header{"Content-Type": {"text/plain"}}, metrics[0].Request.Header)
+	require.Equal(t, http.Header{"X-Request-Id": {"1"}}, metrics[0].Response.Header)
+
+	require.Empty(t, metrics[1].Request.Body)
+	require.Empty(t, metrics[1].Response.Body)
+	require.Equal(t, 11, metrics[1].Response.WrittenBodyBytes)
+	require.Len(t, metrics[1].Request.Header, 2)
+
+	require.Equal(t, "hell", string(metrics[2].Request.Body))
+	require.Equal(t, "hell", string(metrics[2].Response.Body))
+	require.Len(t, metrics[2].Response.Header, 2)
+
+	// the global options are unchanged
+	require.Equal(t, 4, collector.Options.CollectRequestBody)
+}
+
+func TestCollectRegistrations(t *testing.T) {
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+	})
+
+	var calls []string
+	record := func(name string) httpmetrics.MetricsFunc {
+		return func(httpmetrics.Metrics) {
+			calls = append(calls, name)
+		}
+	}
+	serve := func(path string) []string {
+		calls = nil
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		return calls
+	}
+
+	a := collector.Collect(record("a"), "/users/{id}", "/users/{id}")
+	b := collector.Collect(record("b"), "/users/{id}", "/posts")
+	def1 := collector.Collect(record("default1"))
+	def2 := collector.Collect(record("default2"), "*")
+	require.Equal(t, []string{"a", "b"}, serve("/users/1"))
+	require.Equal(t, []string{"b"}, serve("/posts"))
+	require.Equal(t, []string{"default1", "default2"}, serve("/other"))
+
+	included := collector.CollectWithOptions(record("c"), httpmetrics.CollectOptions{IncludeDefault: true}, "/posts")
+	require.Equal(t, []string{"b", "c", "default1", "default2"}, serve("/posts"))
+	require.Equal(t, []string{"a", "b"}, serve("/users/1"))
+	included.Remove()
+
+	a.Remove()
+	a.Remove()
+	require.Equal(t, []string{"b"}, serve("/users/1"))
+	b.Remove()
+	require.Equal(t, []string{"default1", "default2"}, serve("/users/1"))
+	def1.Remove()
+	require.Equal(t, []string{"default2"}, serve("/posts"))
+	def2.Remove()
+	require.Empty(t, serve("/posts"))
+
+	// a removed pattern can be registered again
+	collector.Collect(record("d"), "/users/{name}")
+	require.Equal(t, []string{"d"}, serve("/users/1"))
+}
+
+func TestCollectIncludeDefault(t *testing.T) {
+	var calls []string
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler:        HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		IncludeDefault: true,
+		CustomRouter: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch m := w.(type) {
+			case *httpmetrics.MetricsRequest:
+				m.Collect = r.URL.Path == "/custom"
+			case httpmetrics.Metrics:
+				calls = append(calls, "custom")
+			}
+		}),
+	})
+	collector.Collect(func(httpmetrics.Metrics) { calls = append(calls, "path") }, "/path")
+	collector.Collect(func(httpmetrics.Metrics) { calls = append(calls, "default") })
+
+	for _, path := range []string{"/path", "/custom", "/other"} {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	require.Equal(t, []string{"path", "default", "custom", "default", "default"}, calls)
+}
+
+func TestCollectRetainBodies(t *testing.T) {
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+			w.Write(body)
+		}),
+		CollectRequestBody:  1024,
+		CollectResponseBody: 1024,
+		RetainBodies:        httpmetrics.RetainErrors(http.StatusInternalServerError, time.Hour),
+	})
+
+	var metrics []httpmetrics.Metrics
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	})
+	for _, path := range []string{"/ok", "/fail", "/ok"} {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader("payload "+path)))
+	}
+
+	require.Len(t, metrics, 3)
+	require.Nil(t, metrics[0].Request.Body)
+	require.Nil(t, metrics[0].Response.Body)
+	require.Equal(t, 11, metrics[0].Request.ConsumedBodyBytes)
+	require.Equal(t, 11, metrics[0].Response.WrittenBodyBytes)
+	require.Equal(t, "payload /fail", string(metrics[1].Request.Body))
+	require.Equal(t, "payload /fail", string(metrics[1].Response.Body))
+	// the retained bodies are not reused by the following request
+	require.Nil(t, metrics[2].Response.Body)
+	require.Equal(t, "payload /fail", string(metrics[1].Response.Body))
+}
+
+func TestCollectTruncated(t *testing.T) {
+	var metrics []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/full" {
+				_, _ = ioutil.ReadAll(r.Body)
+			} else {
+				_, _ = io.ReadFull(r.Body, make([]byte, 4))
+			}
+			w.Header().Set("Content-Length", "11")
+			io.WriteString(w, "hello world")
+		}),
+		CollectRequestBody:  5,
+		CollectResponseBody: 5,
+		DrainRequestBody:    1024,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	})
+	collector.CollectWithOptions(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	}, httpmetrics.CollectOptions{DrainRequestBody: -1}, "/nodrain")
+
+	for _, path := range []string{"/full", "/partial", "/nodrain"} {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader("hello world")))
+	}
+	continueRequest := httptest.NewRequest(http.MethodPost, "/partial", strings.NewReader("hello world"))
+	continueRequest.Header.Set("Expect", "100-continue")
+	collector.ServeHTTP(httptest.NewRecorder(), continueRequest)
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/full", nil))
+	require.Len(t, metrics, 5)
+
+	require.Equal(t, "hello", string(metrics[0].Request.Body))
+	require.True(t, metrics[0].Request.Truncated)
+	require.Equal(t, 11, metrics[0].Request.ConsumedBodyBytes)
+	require.Equal(t, 11, metrics[0].Request.TotalBodyBytes)
+	require.Equal(t, int64(11), metrics[0].Request.ContentLength)
+	require.Equal(t, "hello", string(metrics[0].Response.Body))
+	require.True(t, metrics[0].Response.Truncated)
+	require.Equal(t, int64(11), metrics[0].Response.ContentLength)
+
+	// the unread bytes are drained and collected up to the limit
+	require.Equal(t, "hello", string(metrics[1].Request.Body))
+	require.True(t, metrics[1].Request.Truncated)
+	require.Equal(t, 4, metrics[1].Request.ConsumedBodyBytes)
+	require.Equal(t, 11, metrics[1].Request.TotalBodyBytes)
+
+	// disabled for the route, the truncation of the unread bytes is not known
+	require.False(t, metrics[2].Request.Truncated)
+	require.Equal(t, 4, metrics[2].Request.ConsumedBodyBytes)
+	require.Equal(t, -1, metrics[2].Request.TotalBodyBytes)
+
+	// the client waits for the handler to read the body
+	require.Equal(t, 4, metrics[3].Request.ConsumedBodyBytes)
+	require.Equal(t, -1, metrics[3].Request.TotalBodyBytes)
+
+	require.False(t, metrics[4].Request.Truncated)
+	require.Equal(t, 0, metrics[4].Request.TotalBodyBytes)
+}
+
+func TestCollectUnreadBody(t *testing.T) {
+	var metrics []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler:            HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		CollectRequestBody: 5,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	})
+
+	// the body is read until the limit, even if it arrives in chunks
+	req := httptest.NewRequest(http.MethodPost, "/", iotest.OneByteReader(strings.NewReader("hello world")))
+	collector.ServeHTTP(httptest.NewRecorder(), req)
+	broken := errors.New("broken")
+	req = httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("he"), iotest.ErrReader(broken)))
+	collector.ServeHTTP(httptest.NewRecorder(), req)
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hi")))
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set("Expect", "100-continue")
+	collector.ServeHTTP(httptest.NewRecorder(), req)
+	require.Len(t, metrics, 4)
+
+	require.Equal(t, "hello", string(metrics[0].Request.Body))
+	require.True(t, metrics[0].Request.Truncated)
+	require.NoError(t, metrics[0].Request.ReadError)
+	require.Equal(t, 0, metrics[0].Request.ConsumedBodyBytes)
+	require.Equal(t, -1, metrics[0].Request.TotalBodyBytes)
+
+	require.Equal(t, "he", string(metrics[1].Request.Body))
+	require.Equal(t, broken, metrics[1].Request.ReadError)
+
+	require.Equal(t, "hi", string(metrics[2].Request.Body))
+	require.False(t, metrics[2].Request.Truncated)
+	require.NoError(t, metrics[2].Request.ReadError)
+	require.Equal(t, 2, metrics[2].Request.TotalBodyBytes)
+
+	// the client waits for the handler to read the body
+	require.Empty(t, metrics[3].Request.Body)
+	require.Equal(t, -1, metrics[3].Request.TotalBodyBytes)
+}
+
+func TestCollectUnreadBodyCaptureMode(t *testing.T) {
+	var metrics []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler:            HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		CollectRequestBody: 10,
+		DrainRequestBody:   1000,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	})
+	collector.CollectWithOptions(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	}, httpmetrics.CollectOptions{CaptureMode: httpmetrics.CaptureTail}, "/tail")
+	collector.CollectWithOptions(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	}, httpmetrics.CollectOptions{CaptureMode: httpmetrics.CaptureHeadTail}, "/headtail")
+
+	for _, path := range []string{"/head", "/tail", "/headtail"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("0123456789abcdefghijklm"))
+		collector.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	require.Len(t, metrics, 3)
+
+	// the drained bytes pass through the buffer, so the tail is the end of the whole body
+	require.Equal(t, "0123456789", string(metrics[0].Request.Body))
+	require.Equal(t, "defghijklm", string(metrics[1].Request.Body))
+	require.Equal(t, "01234\n...[13 bytes elided]...\nijklm", string(metrics[2].Request.Body))
+	for _, m := range metrics {
+		require.True(t, m.Request.Truncated)
+		require.Equal(t, 0, m.Request.ConsumedBodyBytes)
+		require.Equal(t, 23, m.Request.TotalBodyBytes)
+	}
+}
+
+func TestCollectReadBodyTimeout(t *testing.T) {
+	metrics := make(chan httpmetrics.Metrics, 1)
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler:            HandleAllRequests(func(http.ResponseWriter, *http.Request) {}),
+		CollectRequestBody: 1024,
+		ReadBodyTimeout:    50 * time.Millisecond,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics <- m.Clone()
+	})
+	s := httptest.NewServer(collector)
+	defer s.Close()
+
+	// the client sends the first bytes of the body and stalls
+	body, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte("hello"))
+	res, err := http.Post(s.URL, "text/plain", body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+
+	m := <-metrics
+	require.Equal(t, "hello", string(m.Request.Body))
+	require.True(t, errors.Is(m.Request.ReadError, os.ErrDeadlineExceeded), "%v", m.Request.ReadError)
+}
+
+func TestCollectCaptureMode(t *testing.T) {
+	var metrics []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(w, r.Body)
+		}),
+		CollectRequestBody:  10,
+		CollectResponseBody: 10,
+		CaptureMode:         httpmetrics.CaptureTail,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	})
+	collector.CollectWithOptions(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	}, httpmetrics.CollectOptions{CaptureMode: httpmetrics.CaptureHeadTail}, "/headtail")
+
+	payload := `[{"id":1},{"id":2},{"id":3},{"error":"timeout"}]`
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/tail", strings.NewReader(payload)))
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/headtail", strings.NewReader(payload)))
+	require.Len(t, metrics, 2)
+
+	require.Equal(t, `timeout"}]`, string(metrics[0].Request.Body))
+	require.Equal(t, `timeout"}]`, string(metrics[0].Response.Body))
+	require.True(t, metrics[0].Request.Truncated)
+	require.True(t, metrics[0].Response.Truncated)
+
+	require.Equal(t, "[{\"id\n...[38 bytes elided]...\nut\"}]", string(metrics[1].Request.Body))
+	require.Equal(t, "[{\"id\n...[38 bytes elided]...\nut\"}]", string(metrics[1].Response.Body))
+	require.Equal(t, len(payload), metrics[1].Response.WrittenBodyBytes)
+}
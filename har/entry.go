@@ -0,0 +1,261 @@
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/talon-one/go-httpmetrics"
+)
+
+// NewEntry converts the metrics of a request into a HAR entry.
+// Only the collected parts of the bodies are exported, see httpmetrics.CollectOptions.
+func NewEntry(m httpmetrics.Metrics) Entry {
+	entry := Entry{
+		StartedDateTime: m.Start,
+		Time:            milliseconds(m.Duration),
+		Route:           m.Route,
+		Timings: Timings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			SSL:     -1,
+		},
+	}
+
+	// the server side view only knows when the handler wrote the first byte
+	wait := m.TimeToFirstByte
+	if wait == 0 {
+		wait = m.Duration
+	}
+	entry.Timings.Receive = milliseconds(m.Duration - wait)
+	if c := m.Client; c != nil {
+		// the client side view knows how the connection was obtained, HAR includes ssl in connect
+		if c.DNS > 0 {
+			entry.Timings.DNS = milliseconds(c.DNS)
+		}
+		if c.Connect > 0 || c.TLSHandshake > 0 {
+			entry.Timings.Connect = milliseconds(c.Connect + c.TLSHandshake)
+		}
+		if c.TLSHandshake > 0 {
+			entry.Timings.SSL = milliseconds(c.TLSHandshake)
+		}
+		entry.Timings.Blocked = milliseconds(max(c.GotConn-c.DNS-c.Connect-c.TLSHandshake, 0))
+		wait = max(wait-c.GotConn, 0)
+	}
+	entry.Timings.Wait = milliseconds(wait)
+
+	if r := m.Request.Request; r != nil {
+		entry.Request = Request{
+			Method:      r.Method,
+			URL:         requestURL(r),
+			HTTPVersion: r.Proto,
+			Cookies:     cookies(r.Cookies()),
+			Headers:     nameValues(r.Header),
+			QueryString: []NameValue{},
+			HeadersSize: -1,
+			BodySize:    int64(m.Request.ConsumedBodyBytes),
+		}
+		if m.Request.TotalBodyBytes > 0 {
+			entry.Request.BodySize = int64(m.Request.TotalBodyBytes)
+		}
+		if r.URL != nil {
+			entry.Request.QueryString = nameValues(r.URL.Query())
+		}
+		if len(m.Request.Body) > 0 {
+			entry.Request.PostData = postData(r.Header.Get("Content-Type"), m.Request.Body)
+		}
+		entry.Response.HTTPVersion = r.Proto
+	} else {
+		entry.Request = Request{
+			Cookies:     []Cookie{},
+			Headers:     []NameValue{},
+			QueryString: []NameValue{},
+			HeadersSize: -1,
+		}
+	}
+
+	entry.Response.Status = m.Response.Code
+	entry.Response.StatusText = http.StatusText(m.Response.Code)
+	entry.Response.Cookies = cookies((&http.Response{Header: m.Response.Header}).Cookies())
+	entry.Response.Headers = nameValues(m.Response.Header)
+	entry.Response.RedirectURL = m.Response.Header.Get("Location")
+	entry.Response.HeadersSize = -1
+	entry.Response.BodySize = int64(m.Response.WrittenBodyBytes)
+	entry.Response.Content = Content{
+		Size:     int64(m.Response.WrittenBodyBytes),
+		MimeType: m.Response.Header.Get("Content-Type"),
+	}
+	entry.Response.Content.Text, entry.Response.Content.Encoding = encodeBody(m.Response.Body)
+	return entry
+}
+
+// Record is the httpmetrics.Metrics like representation of a HAR entry
+type Record struct {
+	Start           time.Time
+	Duration        time.Duration
+	TimeToFirstByte time.Duration
+	Route           string
+	Request         httpmetrics.Request
+	Response        httpmetrics.Response
+}
+
+// Record converts the entry back into a Record
+func (entry Entry) Record() (Record, error) {
+	record := Record{
+		Start:    entry.StartedDateTime,
+		Duration: duration(entry.Time),
+		Route:    entry.Route,
+	}
+	if entry.Timings.Receive >= 0 && entry.Timings.Receive < entry.Time {
+		record.TimeToFirstByte = duration(entry.Time - entry.Timings.Receive)
+	}
+
+	var body []byte
+	if entry.Request.PostData != nil {
+		var err error
+		body, err = decodeBody(entry.Request.PostData.Text, entry.Request.PostData.Encoding)
+		if err != nil {
+			return record, err
+		}
+	}
+	req, err := http.NewRequest(entry.Request.Method, entry.Request.URL, bytes.NewReader(body))
+	if err != nil {
+		return record, err
+	}
+	if entry.Request.HTTPVersion != "" {
+		if major, minor, ok := http.ParseHTTPVersion(entry.Request.HTTPVersion); ok {
+			req.Proto, req.ProtoMajor, req.ProtoMinor = entry.Request.HTTPVersion, major, minor
+		}
+	}
+	req.Header = header(entry.Request.Headers)
+	record.Request = httpmetrics.Request{
+		Request:           req,
+		Body:              body,
+		ConsumedBodyBytes: int(entry.Request.BodySize),
+		Truncated:         len(body) > 0 && int64(len(body)) < entry.Request.BodySize,
+		TotalBodyBytes:    int(entry.Request.BodySize),
+	}
+
+	responseBody, err := decodeBody(entry.Response.Content.Text, entry.Response.Content.Encoding)
+	if err != nil {
+		return record, err
+	}
+	record.Response = httpmetrics.Response{
+		Code:             entry.Response.Status,
+		Header:           header(entry.Response.Headers),
+		Body:             responseBody,
+		WrittenBodyBytes: int(entry.Response.Content.Size),
+		Truncated:        len(responseBody) > 0 && int64(len(responseBody)) < entry.Response.Content.Size,
+		ContentLength:    -1,
+	}
+	if n, err := strconv.ParseInt(record.Response.Header.Get("Content-Length"), 10, 64); err == nil && n >= 0 {
+		record.Response.ContentLength = n
+	}
+	return record, nil
+}
+
+func requestURL(r *http.Request) string {
+	if r.URL == nil {
+		return ""
+	}
+	u := *r.URL
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	return u.String()
+}
+
+func nameValues(values map[string][]string) []NameValue {
+	list := []NameValue{}
+	for name, v := range values {
+		for _, value := range v {
+			list = append(list, NameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func header(list []NameValue) http.Header {
+	h := make(http.Header, len(list))
+	for _, nv := range list {
+		h.Add(nv.Name, nv.Value)
+	}
+	return h
+}
+
+func cookies(list []*http.Cookie) []Cookie {
+	result := make([]Cookie, 0, len(list))
+	for _, c := range list {
+		cookie := Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			cookie.Expires = &expires
+		}
+		result = append(result, cookie)
+	}
+	return result
+}
+
+func postData(contentType string, body []byte) *PostData {
+	data := &PostData{
+		MimeType: contentType,
+		Params:   []Param{},
+	}
+	data.Text, data.Encoding = encodeBody(body)
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/x-www-form-urlencoded" {
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			for _, nv := range nameValues(values) {
+				data.Params = append(data.Params, Param{Name: nv.Name, Value: nv.Value})
+			}
+		}
+	}
+	return data
+}
+
+func encodeBody(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func decodeBody(text, encoding string) ([]byte, error) {
+	if text == "" {
+		return nil, nil
+	}
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func duration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
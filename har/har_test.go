@@ -0,0 +1,223 @@
+package har_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+	"github.com/talon-one/go-httpmetrics/har"
+)
+
+func testCollector(fn httpmetrics.MetricsFunc) *httpmetrics.Collector {
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", HttpOnly: true})
+			if r.URL.Path == "/binary" {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write([]byte{0xff, 0x00, 0xfe})
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		}),
+		CollectRequestBody:  1024,
+		CollectResponseBody: 1024,
+	})
+	collector.Collect(fn)
+	return collector
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := har.NewWriter(&buf, har.Options{Comment: "test"})
+	collector := testCollector(w.Observe)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/echo?a=1&b=2", strings.NewReader("a=b&c=d"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "token", Value: "abc"})
+	collector.ServeHTTP(httptest.NewRecorder(), req)
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/binary", nil))
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Err())
+	require.Equal(t, 2, w.Entries())
+	require.Equal(t, har.ErrClosed, w.WriteEntry(har.Entry{}))
+
+	require.True(t, json.Valid(buf.Bytes()))
+	log, err := har.Read(&buf)
+	require.NoError(t, err)
+	require.Equal(t, "1.2", log.Version)
+	require.Equal(t, har.DefaultCreator, log.Creator)
+	require.Equal(t, "test", log.Comment)
+	require.Len(t, log.Entries, 2)
+
+	entry := log.Entries[0]
+	require.Equal(t, "*", entry.Route)
+	require.False(t, entry.StartedDateTime.IsZero())
+	require.Equal(t, http.MethodPost, entry.Request.Method)
+	require.Equal(t, "http://example.com/echo?a=1&b=2", entry.Request.URL)
+	require.Equal(t, "HTTP/1.1", entry.Request.HTTPVersion)
+	require.Equal(t, []har.NameValue{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}, entry.Request.QueryString)
+	require.Equal(t, []har.Cookie{{Name: "token", Value: "abc"}}, entry.Request.Cookies)
+	require.Equal(t, int64(7), entry.Request.BodySize)
+	require.Equal(t, &har.PostData{
+		MimeType: "application/x-www-form-urlencoded",
+		Params:   []har.Param{{Name: "a", Value: "b"}, {Name: "c", Value: "d"}},
+		Text:     "a=b&c=d",
+	}, entry.Request.PostData)
+	require.Equal(t, http.StatusCreated, entry.Response.Status)
+	require.Equal(t, "Created", entry.Response.StatusText)
+	require.Equal(t, []har.Cookie{{Name: "session", Value: "1", HTTPOnly: true}}, entry.Response.Cookies)
+	require.Equal(t, har.Content{Size: 7, MimeType: "text/plain", Text: "a=b&c=d"}, entry.Response.Content)
+	require.Equal(t, float64(-1), entry.Timings.DNS)
+	require.InDelta(t, entry.Time, entry.Timings.Send+entry.Timings.Wait+entry.Timings.Receive, 0.001)
+
+	entry = log.Entries[1]
+	require.Nil(t, entry.Request.PostData)
+	require.Equal(t, har.Content{Size: 3, MimeType: "application/octet-stream", Text: "/wD+", Encoding: "base64"}, entry.Response.Content)
+}
+
+func TestWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := har.NewWriter(&buf, har.Options{Creator: har.Creator{Name: "test", Version: "0.1"}})
+	require.NoError(t, w.Close())
+
+	log, err := har.Read(&buf)
+	require.NoError(t, err)
+	require.Equal(t, har.Creator{Name: "test", Version: "0.1"}, log.Creator)
+	require.Len(t, log.Entries, 0)
+}
+
+func TestReadRecords(t *testing.T) {
+	var buf bytes.Buffer
+	w := har.NewWriter(&buf, har.Options{})
+	collector := testCollector(w.Observe)
+
+	req := httptest.NewRequest(http.MethodPut, "/echo", strings.NewReader(`{"hello":"world"}`))
+	req.Header.Set("Content-Type", "application/json")
+	collector.ServeHTTP(httptest.NewRecorder(), req)
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/binary", nil))
+	require.NoError(t, w.Close())
+
+	records, err := har.ReadRecords(&buf)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	record := records[0]
+	require.Equal(t, "*", record.Route)
+	require.Equal(t, http.MethodPut, record.Request.Method)
+	require.Equal(t, "http://example.com/echo", record.Request.URL.String())
+	require.Equal(t, "application/json", record.Request.Header.Get("Content-Type"))
+	require.Equal(t, `{"hello":"world"}`, string(record.Request.Body))
+	require.False(t, record.Request.Truncated)
+	require.Equal(t, 17, record.Request.TotalBodyBytes)
+	body, err := ioutil.ReadAll(record.Request.Request.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"hello":"world"}`, string(body))
+	require.Equal(t, http.StatusCreated, record.Response.Code)
+	require.Equal(t, `{"hello":"world"}`, string(record.Response.Body))
+	require.Equal(t, "text/plain", record.Response.Header.Get("Content-Type"))
+	require.Equal(t, 17, record.Response.WrittenBodyBytes)
+
+	require.Equal(t, []byte{0xff, 0x00, 0xfe}, records[1].Response.Body)
+}
+
+func TestEntryTimings(t *testing.T) {
+	var m httpmetrics.Metrics
+	m.Request.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	m.Response.Header = make(http.Header)
+	m.Duration = 30 * time.Millisecond
+	m.TimeToFirstByte = 10 * time.Millisecond
+
+	entry := har.NewEntry(m)
+	require.Equal(t, float64(30), entry.Time)
+	require.Equal(t, har.Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: 10, Receive: 20}, entry.Timings)
+
+	record, err := entry.Record()
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Millisecond, record.Duration)
+	require.Equal(t, 10*time.Millisecond, record.TimeToFirstByte)
+
+	// the timings of a Transport request until the connection was obtained
+	m.Client = &httpmetrics.ClientTimings{
+		DNS:          2 * time.Millisecond,
+		Connect:      3 * time.Millisecond,
+		TLSHandshake: 4 * time.Millisecond,
+		GotConn:      10 * time.Millisecond,
+	}
+	m.TimeToFirstByte = 15 * time.Millisecond
+	entry = har.NewEntry(m)
+	require.Equal(t, har.Timings{Blocked: 1, DNS: 2, Connect: 7, SSL: 4, Wait: 5, Receive: 15}, entry.Timings)
+
+	m.Client = &httpmetrics.ClientTimings{GotConn: time.Millisecond, Reused: true}
+	entry = har.NewEntry(m)
+	require.Equal(t, har.Timings{Blocked: 1, DNS: -1, Connect: -1, SSL: -1, Wait: 14, Receive: 15}, entry.Timings)
+}
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRotatingWriter(t *testing.T) {
+	var logs []*closeBuffer
+	w := har.NewRotatingWriter(func(index int) (io.WriteCloser, error) {
+		require.Equal(t, len(logs), index)
+		b := &closeBuffer{}
+		logs = append(logs, b)
+		return b, nil
+	}, har.RotateOptions{MaxEntries: 2})
+	collector := testCollector(w.Observe)
+
+	for i := 0; i < 5; i++ {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	require.Len(t, logs, 3)
+	require.True(t, logs[0].closed)
+	require.True(t, logs[1].closed)
+	require.False(t, logs[2].closed)
+	require.NoError(t, w.Close())
+	require.True(t, logs[2].closed)
+
+	for i, expected := range []int{2, 2, 1} {
+		log, err := har.Read(&logs[i].Buffer)
+		require.NoError(t, err)
+		require.Len(t, log.Entries, expected)
+	}
+}
+
+func TestRotatingWriterMaxBytes(t *testing.T) {
+	var logs []*closeBuffer
+	w := har.NewRotatingWriter(func(index int) (io.WriteCloser, error) {
+		b := &closeBuffer{}
+		logs = append(logs, b)
+		return b, nil
+	}, har.RotateOptions{MaxBytes: 1})
+	collector := testCollector(w.Observe)
+
+	for i := 0; i < 3; i++ {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	require.NoError(t, w.Close())
+	require.Len(t, logs, 3)
+	for _, b := range logs {
+		require.True(t, b.closed)
+		log, err := har.Read(&b.Buffer)
+		require.NoError(t, err)
+		require.Len(t, log.Entries, 1)
+	}
+}
@@ -0,0 +1,32 @@
+package har
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Read parses a HAR file
+func Read(r io.Reader) (*Log, error) {
+	var file File
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file.Log, nil
+}
+
+// ReadRecords parses a HAR file and converts all entries into Records
+func ReadRecords(r io.Reader) ([]Record, error) {
+	log, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0, len(log.Entries))
+	for _, entry := range log.Entries {
+		record, err := entry.Record()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
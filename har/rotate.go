@@ -0,0 +1,123 @@
+package har
+
+import (
+	"io"
+	"sync"
+
+	"github.com/talon-one/go-httpmetrics"
+)
+
+// RotateOptions controls the behavior of the RotatingWriter
+type RotateOptions struct {
+	Options
+	// MaxEntries starts a new log after the specified count of entries, 0 means no limit
+	MaxEntries int
+	// MaxBytes starts a new log once the current log exceeds the specified size, 0 means no limit
+	MaxBytes int64
+}
+
+// RotatingWriter writes HAR entries into a sequence of logs, e.g. files,
+// each log is a valid HAR file once it was rotated or the RotatingWriter was closed
+type RotatingWriter struct {
+	open    func(index int) (io.WriteCloser, error)
+	options RotateOptions
+
+	mu      sync.Mutex
+	index   int
+	current *Writer
+	closer  io.Closer
+	closed  bool
+	err     error
+}
+
+// NewRotatingWriter creates a new RotatingWriter, open is called with an increasing index to create the next log
+func NewRotatingWriter(open func(index int) (io.WriteCloser, error), options RotateOptions) *RotatingWriter {
+	return &RotatingWriter{
+		open:    open,
+		options: options,
+	}
+}
+
+// Observe writes the metrics as a HAR entry, it can be used as a httpmetrics.MetricsFunc.
+// Errors are reported by Err and Close.
+func (w *RotatingWriter) Observe(m httpmetrics.Metrics) {
+	_ = w.WriteEntry(NewEntry(m))
+}
+
+// WriteEntry writes an entry to the current log and rotates it if a limit was reached
+func (w *RotatingWriter) WriteEntry(entry Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.current == nil {
+		wc, err := w.open(w.index)
+		if err != nil {
+			w.setError(err)
+			return err
+		}
+		w.index++
+		w.current = NewWriter(wc, w.options.Options)
+		w.closer = wc
+	}
+
+	if err := w.current.WriteEntry(entry); err != nil {
+		w.setError(err)
+		return err
+	}
+
+	if (w.options.MaxEntries > 0 && w.current.Entries() >= w.options.MaxEntries) ||
+		(w.options.MaxBytes > 0 && w.current.Size() >= w.options.MaxBytes) {
+		return w.rotate()
+	}
+	return nil
+}
+
+// Rotate closes the current log, the next entry is written to a new log
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+// Close closes the current log
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	return w.err
+}
+
+// Err returns the first error that occurred while writing
+func (w *RotatingWriter) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *RotatingWriter) rotate() error {
+	if w.current == nil {
+		return nil
+	}
+	err := w.current.Close()
+	if closeErr := w.closer.Close(); err == nil {
+		err = closeErr
+	}
+	w.current = nil
+	w.closer = nil
+	w.setError(err)
+	return err
+}
+
+func (w *RotatingWriter) setError(err error) {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+}
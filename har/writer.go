@@ -0,0 +1,145 @@
+package har
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/talon-one/go-httpmetrics"
+)
+
+// ErrClosed is returned when writing to a closed Writer
+var ErrClosed = errors.New("har: writer is closed")
+
+// Options controls the behavior of the Writer
+type Options struct {
+	// Creator is written into the log, defaults to DefaultCreator
+	Creator Creator
+	// Comment is written into the log
+	Comment string
+}
+
+// Writer streams a HAR log to an io.Writer, the log is only valid after Close was called
+type Writer struct {
+	options Options
+
+	mu      sync.Mutex
+	w       *countingWriter
+	entries int
+	closed  bool
+	err     error
+}
+
+// NewWriter creates a new Writer
+func NewWriter(w io.Writer, options Options) *Writer {
+	if options.Creator.Name == "" {
+		options.Creator = DefaultCreator
+	}
+	return &Writer{
+		options: options,
+		w:       &countingWriter{w: w},
+	}
+}
+
+// Observe writes the metrics as a HAR entry, it can be used as a httpmetrics.MetricsFunc.
+// Errors are reported by Err and Close.
+func (w *Writer) Observe(m httpmetrics.Metrics) {
+	_ = w.WriteEntry(NewEntry(m))
+}
+
+// WriteEntry writes an entry to the log
+func (w *Writer) WriteEntry(entry Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.entries == 0 {
+		w.writeHeader()
+	} else {
+		w.write([]byte(",\n"))
+	}
+	w.write(b)
+	w.entries++
+	return w.err
+}
+
+// Close writes the end of the log, it does not close the underlying io.Writer
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.err
+	}
+	if w.entries == 0 {
+		w.writeHeader()
+	}
+	w.write([]byte("\n]}}\n"))
+	w.closed = true
+	return w.err
+}
+
+// Err returns the first error that occurred while writing
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Entries returns the count of written entries
+func (w *Writer) Entries() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.entries
+}
+
+// Size returns the count of written bytes
+func (w *Writer) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.n
+}
+
+func (w *Writer) writeHeader() {
+	creator, err := json.Marshal(w.options.Creator)
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.write([]byte(`{"log":{"version":"` + Version + `","creator":`))
+	w.write(creator)
+	if w.options.Comment != "" {
+		comment, err := json.Marshal(w.options.Comment)
+		if err != nil {
+			w.err = err
+			return
+		}
+		w.write([]byte(`,"comment":`))
+		w.write(comment)
+	}
+	w.write([]byte(`,"entries":[` + "\n"))
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(b)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"io"
+	"strconv"
+	"sync"
+)
+
+// CaptureMode selects the part of a body that is collected by a Buffer
+type CaptureMode int
+
+const (
+	// CaptureHead collects the first bytes of a body
+	CaptureHead CaptureMode = iota
+	// CaptureTail collects the last bytes of a body
+	CaptureTail
+	// CaptureHeadTail collects the first and the last bytes of a body, separated by an elision marker
+	CaptureHeadTail
+)
+
+// chunkSize is the size of the chunks that ReadN reads
+const chunkSize = 32 << 10
+
+// chunkPool holds the chunks that ReadN reads into
+var chunkPool = sync.Pool{
+	New: func() interface{} {
+		return new([chunkSize]byte)
+	},
+}
+
+// Buffer collects up to a maximum size of the head, the tail or both of a body
+type Buffer struct {
+	mode    CaptureMode
+	maxSize int
+	head    LimitedBuffer
+	tail    RingBuffer
+	written int
+	// joined reports whether the tail was appended to the head
+	joined bool
+}
+
+// SetLimit sets the capture mode and the maximum size of the buffer,
+// for CaptureHeadTail the maximum size is split between the head and the tail
+func (b *Buffer) SetLimit(mode CaptureMode, maxSize int) {
+	b.mode = mode
+	b.maxSize = maxSize
+	switch mode {
+	case CaptureTail:
+		b.head.MaxSize, b.tail.MaxSize = 0, maxSize
+	case CaptureHeadTail:
+		b.head.MaxSize, b.tail.MaxSize = maxSize-maxSize/2, maxSize/2
+	default:
+		b.head.MaxSize, b.tail.MaxSize = maxSize, 0
+	}
+}
+
+// Write writes a byte slice to the buffer and returns the written byte count
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.written += len(p)
+	switch b.mode {
+	case CaptureTail:
+		return b.tail.Write(p)
+	case CaptureHeadTail:
+		if remaining := max(b.head.MaxSize-b.head.Len(), 0); remaining < len(p) {
+			_, _ = b.head.Write(p[:remaining])
+			_, _ = b.tail.Write(p[remaining:])
+			return len(p), nil
+		}
+	}
+	return b.head.Write(p)
+}
+
+// ReadN reads up to n bytes from r and writes them to the buffer, so they are collected like written bytes,
+// it returns the read byte count and the error of r
+func (b *Buffer) ReadN(r io.Reader, n int) (int, error) {
+	chunk := chunkPool.Get().(*[chunkSize]byte)
+	defer chunkPool.Put(chunk)
+	var read int
+	for read < n {
+		m, err := r.Read(chunk[:min(chunkSize, n-read)])
+		_, _ = b.Write(chunk[:m])
+		read += m
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// MaxSize returns the maximum size of the collected body
+func (b *Buffer) MaxSize() int {
+	return b.maxSize
+}
+
+// Bytes returns the collected body, for CaptureHeadTail the head and the tail are separated by a marker
+// with the count of elided bytes if the body exceeded the maximum size
+func (b *Buffer) Bytes() []byte {
+	switch b.mode {
+	case CaptureTail:
+		return b.tail.Bytes()
+	case CaptureHeadTail:
+		if !b.joined && b.maxSize > 0 && b.tail.Written() > 0 {
+			b.joined = true
+			tail := b.tail.Bytes()
+			if elided := b.written - b.head.Len() - len(tail); elided > 0 {
+				b.head.Buffer.WriteString("\n...[")
+				b.head.Buffer.Write(strconv.AppendInt(b.head.AvailableBuffer(), int64(elided), 10))
+				b.head.Buffer.WriteString(" bytes elided]...\n")
+			}
+			b.head.Buffer.Write(tail)
+		}
+	}
+	return b.head.Bytes()
+}
+
+// Truncated reports whether the written bytes exceeded the maximum size and were not collected,
+// a buffer without a positive maximum size collects nothing and is never truncated
+func (b *Buffer) Truncated() bool {
+	return b.maxSize > 0 && b.written > b.maxSize
+}
+
+// Release resets the buffer and returns its storage to a pool,
+// the bytes returned by Bytes must not be used afterwards
+func (b *Buffer) Release() {
+	b.head.Release()
+	b.tail.Release()
+	b.written = 0
+	b.joined = false
+}
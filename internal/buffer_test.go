@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingBuffer(t *testing.T) {
+	buf := RingBuffer{
+		MaxSize: 5,
+	}
+	for _, s := range []string{"He", "llo", " Wo", "r", "ld"} {
+		n, err := buf.Write([]byte(s))
+		require.NoError(t, err)
+		require.Equal(t, len(s), n)
+	}
+	require.Equal(t, "World", string(buf.Bytes()))
+	require.True(t, buf.Truncated())
+	require.Equal(t, 11, buf.Written())
+
+	// Bytes does not change the order of later writes
+	_, _ = buf.Write([]byte("!"))
+	require.Equal(t, "orld!", string(buf.Bytes()))
+	_, _ = buf.Write([]byte("Hello World"))
+	require.Equal(t, "World", string(buf.Bytes()))
+
+	buf.Release()
+	require.Empty(t, buf.Bytes())
+	require.False(t, buf.Truncated())
+	_, _ = buf.Write([]byte("Hi"))
+	require.Equal(t, "Hi", string(buf.Bytes()))
+	require.False(t, buf.Truncated())
+
+	// the storage grows with the written bytes instead of holding MaxSize
+	large := RingBuffer{
+		MaxSize: 1 << 20,
+	}
+	_, _ = large.Write([]byte("Hello"))
+	require.Equal(t, "Hello", string(large.Bytes()))
+	require.True(t, cap(large.buf) <= maxPooledBufferSize, "%d", cap(large.buf))
+	large.Release()
+	large.MaxSize = 1000
+	body := strings.Repeat("0123456789", 120)
+	for i := 0; i < len(body); i += 300 {
+		_, _ = large.Write([]byte(body[i : i+300]))
+	}
+	require.Equal(t, body[200:], string(large.Bytes()))
+	large.Release()
+
+	var empty RingBuffer
+	_, _ = empty.Write([]byte("Hello"))
+	require.Empty(t, empty.Bytes())
+	require.False(t, empty.Truncated())
+}
+
+func TestBuffer(t *testing.T) {
+	tests := []struct {
+		mode      CaptureMode
+		maxSize   int
+		writes    []string
+		expected  string
+		truncated bool
+	}{
+		{CaptureHead, 5, []string{"Hello", " World"}, "Hello", true},
+		{CaptureTail, 5, []string{"Hello", " World"}, "World", true},
+		{CaptureTail, 20, []string{"Hello", " World"}, "Hello World", false},
+		{CaptureHeadTail, 6, []string{"He", "llo W", "orld"}, "Hel\n...[5 bytes elided]...\nrld", true},
+		{CaptureHeadTail, 11, []string{"Hello", " World"}, "Hello World", false},
+		{CaptureHeadTail, 20, []string{"Hello", " World"}, "Hello World", false},
+		{CaptureHeadTail, 1, []string{"Hello"}, "H\n...[4 bytes elided]...\n", true},
+		{CaptureHeadTail, 0, []string{"Hello"}, "", false},
+	}
+	for _, test := range tests {
+		var buf Buffer
+		buf.SetLimit(test.mode, test.maxSize)
+		for _, s := range test.writes {
+			n, err := buf.Write([]byte(s))
+			require.NoError(t, err)
+			require.Equal(t, len(s), n)
+		}
+		require.Equal(t, test.expected, string(buf.Bytes()), "%d %d", test.mode, test.maxSize)
+		require.Equal(t, test.expected, string(buf.Bytes()), "%d %d", test.mode, test.maxSize)
+		require.Equal(t, test.truncated, buf.Truncated(), "%d %d", test.mode, test.maxSize)
+		buf.Release()
+		require.Empty(t, buf.Bytes())
+	}
+}
+
+func TestBufferReadN(t *testing.T) {
+	tests := []struct {
+		mode     CaptureMode
+		expected string
+		// limited is collected from the first 7 bytes
+		limited string
+	}{
+		{CaptureHead, "Hello", "Hello"},
+		{CaptureTail, "World", "llo W"},
+		{CaptureHeadTail, "Hel\n...[6 bytes elided]...\nld", "Hel\n...[2 bytes elided]...\n W"},
+	}
+	for _, test := range tests {
+		var buf Buffer
+		buf.SetLimit(test.mode, 5)
+		n, err := buf.ReadN(iotest.OneByteReader(strings.NewReader("Hello World")), 100)
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, 11, n)
+		require.Equal(t, test.expected, string(buf.Bytes()), "%d", test.mode)
+		require.True(t, buf.Truncated())
+
+		buf.Release()
+		n, err = buf.ReadN(strings.NewReader("Hello World"), 7)
+		require.NoError(t, err)
+		require.Equal(t, 7, n)
+		require.Equal(t, test.limited, string(buf.Bytes()), "%d", test.mode)
+
+		buf.Release()
+		n, err = buf.ReadN(strings.NewReader("Hi"), 100)
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, 2, n)
+		require.Equal(t, "Hi", string(buf.Bytes()), "%d", test.mode)
+		require.False(t, buf.Truncated())
+	}
+}
@@ -3,13 +3,24 @@ package internal
 import (
 	"bytes"
 	"io"
+	"sync"
 	"unicode/utf8"
 )
 
+// maxPooledBufferSize is the maximum capacity of a buffer that is returned to the pool
+const maxPooledBufferSize = 64 << 10
+
+// bufferPool holds the storage of released LimitedBuffers
+var bufferPool sync.Pool
+
 // LimitedBuffer is a bytes.Buffer with a limited byte size
 type LimitedBuffer struct {
 	bytes.Buffer
 	MaxSize int
+	// truncated reports whether bytes were dropped because of MaxSize
+	truncated bool
+	// pooled is reused to return the storage to the pool without allocating
+	pooled *[]byte
 }
 
 // Write writes a byte slice to the buffer and returns the written byte count
@@ -19,6 +30,10 @@ func (b *LimitedBuffer) Write(p []byte) (int, error) {
 
 	remaining := b.MaxSize - currentSize
 
+	if remaining < size {
+		b.truncated = true
+	}
+
 	if remaining <= 0 {
 		return size, nil
 	}
@@ -27,6 +42,7 @@ func (b *LimitedBuffer) Write(p []byte) (int, error) {
 		remaining = size
 	}
 
+	b.acquire()
 	n, err := b.Buffer.Write(p[:remaining])
 	if err != nil {
 		return 0, err
@@ -38,6 +54,16 @@ func (b *LimitedBuffer) Write(p []byte) (int, error) {
 	return size, nil
 }
 
+// acquire uses storage from the pool for an empty buffer
+func (b *LimitedBuffer) acquire() {
+	if b.Cap() == 0 {
+		if pooled, ok := bufferPool.Get().(*[]byte); ok {
+			b.Buffer = *bytes.NewBuffer((*pooled)[:0])
+			b.pooled = pooled
+		}
+	}
+}
+
 // WriteString writes a string into the buffer and returns the written byte count
 func (b *LimitedBuffer) WriteString(s string) (int, error) {
 	return b.Write([]byte(s))
@@ -64,3 +90,25 @@ func (b *LimitedBuffer) WriteRune(r rune) (int, error) {
 	_ = utf8.EncodeRune(buf[:], r)
 	return b.Write(buf[:])
 }
+
+// Truncated reports whether the written bytes exceeded MaxSize and were cut off,
+// a buffer without a positive MaxSize collects nothing and is never truncated
+func (b *LimitedBuffer) Truncated() bool {
+	return b.truncated && b.MaxSize > 0
+}
+
+// Release resets the buffer and returns its storage to a pool,
+// the bytes returned by Bytes must not be used afterwards
+func (b *LimitedBuffer) Release() {
+	p := b.Buffer.Bytes()[:0]
+	b.Buffer = bytes.Buffer{}
+	b.truncated = false
+	if cap(p) > 0 && cap(p) <= maxPooledBufferSize {
+		if b.pooled == nil {
+			b.pooled = new([]byte)
+		}
+		*b.pooled = p
+		bufferPool.Put(b.pooled)
+	}
+	b.pooled = nil
+}
@@ -16,6 +16,7 @@ func TestLimitedBuffer(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, 5, n)
 		require.Equal(t, "Hello", buf.String())
+		require.False(t, buf.Truncated())
 	}
 
 	{
@@ -23,6 +24,7 @@ func TestLimitedBuffer(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, 19, n)
 		require.Equal(t, "Hello World", buf.String())
+		require.True(t, buf.Truncated())
 	}
 
 	{
@@ -59,6 +61,7 @@ func TestLimitedBufferZeroSize(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 5, n)
 	require.Len(t, buf.Bytes(), 0)
+	require.False(t, buf.Truncated())
 }
 
 func TestLimitedBufferNegativeSize(t *testing.T) {
@@ -70,4 +73,27 @@ func TestLimitedBufferNegativeSize(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 5, n)
 	require.Len(t, buf.Bytes(), 0)
+	require.False(t, buf.Truncated())
+}
+
+func TestLimitedBufferRelease(t *testing.T) {
+	buf := LimitedBuffer{
+		MaxSize: 11,
+	}
+	_, err := buf.WriteString("Hello World and Universe")
+	require.NoError(t, err)
+	require.True(t, buf.Truncated())
+	buf.Release()
+	require.False(t, buf.Truncated())
+	require.Equal(t, 0, buf.Len())
+	require.Equal(t, 0, buf.Cap())
+
+	// a released buffer can be used again
+	_, err = buf.WriteString("Hello World")
+	require.NoError(t, err)
+	require.Equal(t, "Hello World", buf.String())
+
+	var empty LimitedBuffer
+	empty.Release()
+	require.Equal(t, 0, empty.Len())
 }
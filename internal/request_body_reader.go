@@ -2,28 +2,43 @@ package internal
 
 import (
 	"io"
+	"net/http"
+	"sync"
 )
 
 // RequestBodyReader is a RequestReader that caches the consumed body bytes
 type RequestBodyReader struct {
 	body     io.ReadCloser
-	buf      LimitedBuffer
+	buf      Buffer
 	read     bool
 	consumed int
+	// drained is the count of bytes that were read after the handler returned
+	drained int
+	eof     bool
+	err     error
 }
 
-// NewRequestBodyReader creates a new RequestBodyReader
-func NewRequestBodyReader(body io.ReadCloser, maxSize int) *RequestBodyReader {
-	r := &RequestBodyReader{
-		body: body,
-	}
-	r.buf.MaxSize = maxSize
+var requestBodyReaderPool = sync.Pool{
+	New: func() interface{} {
+		return new(RequestBodyReader)
+	},
+}
+
+// NewRequestBodyReader creates a new RequestBodyReader that caches the part of the body selected by the mode,
+// it is taken from a pool and must be released once its body is no longer used
+func NewRequestBodyReader(body io.ReadCloser, maxSize int, mode CaptureMode) *RequestBodyReader {
+	r := requestBodyReaderPool.Get().(*RequestBodyReader)
+	r.body = body
+	r.buf.SetLimit(mode, maxSize)
+	// the http.Server passes http.NoBody for requests without a body
+	r.eof = body == http.NoBody
 	return r
 }
 
 func (r *RequestBodyReader) Read(p []byte) (int, error) {
 	r.read = true
 	readN, readErr := r.body.Read(p)
+	r.setError(readErr)
 	if readN > 0 {
 		r.consumed += readN
 		writeN, writeErr := r.buf.Write(p[:readN])
@@ -43,22 +58,82 @@ func (r *RequestBodyReader) Close() error {
 	return r.body.Close()
 }
 
-// Body returns the collected body, if there was no read on the body it attempts to read it
+// Body returns the collected body and the first error returned by the body
 func (r *RequestBodyReader) Body() ([]byte, error) {
-	if !r.read {
-		var err error
-		r.read = true
-		if r.buf.MaxSize > 0 {
-			var n int
-			buf := make([]byte, r.buf.MaxSize)
-			n, err = r.body.Read(buf)
-			if buf != nil {
-				return buf[:n], err
-			}
-		}
-		return nil, err
+	return r.buf.Bytes(), r.err
+}
+
+// Capture reads the body until the maximum size is collected or the body ends if there was no read on the body,
+// one more byte is read to report whether the body was truncated
+func (r *RequestBodyReader) Capture() {
+	if r.read || r.eof || r.buf.MaxSize() <= 0 {
+		return
+	}
+	r.read = true
+	n, err := r.buf.ReadN(r.body, r.buf.MaxSize()+1)
+	r.drained += n
+	r.setError(err)
+}
+
+// setError records the end of the body or the first error
+func (r *RequestBodyReader) setError(err error) {
+	if err == io.EOF {
+		r.eof = true
+	} else if err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// ReadError returns the first error returned by the body, io.EOF is not an error
+func (r *RequestBodyReader) ReadError() error {
+	return r.err
+}
+
+// EOF reports whether the body was read until its end
+func (r *RequestBodyReader) EOF() bool {
+	return r.eof
+}
+
+// ReleaseBody drops the collected body and returns its buffer to a pool
+func (r *RequestBodyReader) ReleaseBody() {
+	r.buf.Release()
+}
+
+// Release drops the collected body and returns the RequestBodyReader to a pool,
+// neither the RequestBodyReader nor its Body must be used afterwards
+func (r *RequestBodyReader) Release() {
+	r.buf.Release()
+	*r = RequestBodyReader{}
+	requestBodyReaderPool.Put(r)
+}
+
+// Truncated reports whether the read body exceeded the collected body,
+// this includes the bytes that were read after the handler returned
+func (r *RequestBodyReader) Truncated() bool {
+	return r.buf.Truncated()
+}
+
+// Drain reads up to limit bytes of the body that were not read yet, they are collected like the consumed bytes,
+// so a tail of the body is collected up to its end
+func (r *RequestBodyReader) Drain(limit int) error {
+	if r.eof || limit <= 0 {
+		return nil
+	}
+	n, err := r.buf.ReadN(r.body, limit)
+	r.drained += n
+	r.setError(err)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// TotalBodyBytes returns the byte count of the whole body, or -1 if the body was not read until its end
+func (r *RequestBodyReader) TotalBodyBytes() int {
+	if !r.eof {
+		return -1
 	}
-	return r.buf.Bytes(), nil
+	return r.consumed + r.drained
 }
 
 // ConsumedBodyBytes returns the byte count of the bytes that have been read by the http.Handler
@@ -1,16 +1,45 @@
 package internal
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 // ResponseWriter is used to collect some metrics for a http response
 type ResponseWriter interface {
 	StatusCode() int
+	// HeaderWritten reports whether the header was written, explicitly or implicitly by writing the body
+	HeaderWritten() bool
+	// SuperfluousWriteHeaders is the count of WriteHeader calls after the header was written
+	SuperfluousWriteHeaders() int
+	// HeaderWrittenAt is the time the header was written, it is zero if no header was written
+	HeaderWrittenAt() time.Time
+	// FirstByteWrittenAt is the time the first body byte was written, it is zero if no body was written
+	FirstByteWrittenAt() time.Time
+	// Hijacked reports whether the connection was hijacked
+	Hijacked() bool
 	Body() []byte
+	// BodyTruncated reports whether the written body exceeded the collected Body
+	BodyTruncated() bool
+	// ReleaseBody drops the collected body and returns its buffer to a pool
+	ReleaseBody()
+	// Release drops the collected body and returns the ResponseWriter to a pool,
+	// neither the ResponseWriter nor its Body must be used afterwards
+	Release()
 	WrittenBodyBytes() int
+	// WriteError returns the first error returned by the underlying http.ResponseWriter
+	WriteError() error
 
 	Header() http.Header
 	Write([]byte) (int, error)
 	WriteHeader(statusCode int)
 	SetCustomMetric(key, value interface{})
 	GetCustomMetric(key interface{}) (interface{}, bool)
+	// Generation identifies the request that uses the ResponseWriter, it changes whenever the ResponseWriter is released
+	Generation() uint64
+	// GetCustomMetricOf returns a custom metric if the ResponseWriter is still used by the request of the generation
+	GetCustomMetricOf(generation uint64, key interface{}) (interface{}, bool)
+
+	// Unwrap returns the underlying http.ResponseWriter, it is used by http.ResponseController
+	Unwrap() http.ResponseWriter
 }
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"io"
+	"net/http"
+)
+
+// writer is a ResponseWriter that implements all optional http.ResponseWriter interfaces,
+// by calling the underlying http.ResponseWriter
+type writer interface {
+	ResponseWriter
+	http.Flusher
+	http.Hijacker
+	io.ReaderFrom
+	http.Pusher
+}
+
+const (
+	flusher = 1 << iota
+	hijacker
+	readerFrom
+	pusher
+)
+
+// writerFeatures returns the optional interfaces (http.Flusher, http.Hijacker, io.ReaderFrom and http.Pusher)
+// that are implemented by w
+func writerFeatures(w http.ResponseWriter) int {
+	var features int
+	if _, ok := w.(http.Flusher); ok {
+		features |= flusher
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		features |= hijacker
+	}
+	if _, ok := w.(io.ReaderFrom); ok {
+		features |= readerFrom
+	}
+	if _, ok := w.(http.Pusher); ok {
+		features |= pusher
+	}
+	return features
+}
+
+// wrap returns a ResponseWriter that implements exactly the optional interfaces of the features
+func wrap(rw writer, features int) ResponseWriter {
+	switch features {
+	case flusher:
+		return struct {
+			ResponseWriter
+			http.Flusher
+		}{rw, rw}
+	case hijacker:
+		return struct {
+			ResponseWriter
+			http.Hijacker
+		}{rw, rw}
+	case flusher | hijacker:
+		return struct {
+			ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{rw, rw, rw}
+	case readerFrom:
+		return struct {
+			ResponseWriter
+			io.ReaderFrom
+		}{rw, rw}
+	case flusher | readerFrom:
+		return struct {
+			ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{rw, rw, rw}
+	case hijacker | readerFrom:
+		return struct {
+			ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, rw, rw}
+	case flusher | hijacker | readerFrom:
+		return struct {
+			ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, rw, rw, rw}
+	case pusher:
+		return struct {
+			ResponseWriter
+			http.Pusher
+		}{rw, rw}
+	case flusher | pusher:
+		return struct {
+			ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{rw, rw, rw}
+	case hijacker | pusher:
+		return struct {
+			ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{rw, rw, rw}
+	case flusher | hijacker | pusher:
+		return struct {
+			ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rw, rw, rw, rw}
+	case readerFrom | pusher:
+		return struct {
+			ResponseWriter
+			io.ReaderFrom
+			http.Pusher
+		}{rw, rw, rw}
+	case flusher | readerFrom | pusher:
+		return struct {
+			ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{rw, rw, rw, rw}
+	case hijacker | readerFrom | pusher:
+		return struct {
+			ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{rw, rw, rw, rw}
+	case flusher | hijacker | readerFrom | pusher:
+		return struct {
+			ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{rw, rw, rw, rw, rw}
+	}
+	return struct {
+		ResponseWriter
+	}{rw}
+}
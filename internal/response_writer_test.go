@@ -0,0 +1,465 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// baseWriter implements all optional interfaces, newTestWriter exposes only a subset of them
+type baseWriter struct {
+	header   http.Header
+	body     bytes.Buffer
+	code     int
+	flushed  int
+	hijacked int
+	pushed   []string
+	readFrom int
+}
+
+func (w *baseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *baseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *baseWriter) WriteHeader(statusCode int) {
+	w.code = statusCode
+}
+
+func (w *baseWriter) Flush() {
+	w.flushed++
+}
+
+func (w *baseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked++
+	return nil, nil, nil
+}
+
+func (w *baseWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFrom++
+	return w.body.ReadFrom(src)
+}
+
+func (w *baseWriter) Push(target string, _ *http.PushOptions) error {
+	w.pushed = append(w.pushed, target)
+	return nil
+}
+
+func newTestWriter(features int) (http.ResponseWriter, *baseWriter) {
+	b := &baseWriter{header: make(http.Header)}
+	type rw = http.ResponseWriter
+	switch features {
+	case flusher:
+		return struct {
+			rw
+			http.Flusher
+		}{b, b}, b
+	case hijacker:
+		return struct {
+			rw
+			http.Hijacker
+		}{b, b}, b
+	case flusher | hijacker:
+		return struct {
+			rw
+			http.Flusher
+			http.Hijacker
+		}{b, b, b}, b
+	case readerFrom:
+		return struct {
+			rw
+			io.ReaderFrom
+		}{b, b}, b
+	case flusher | readerFrom:
+		return struct {
+			rw
+			http.Flusher
+			io.ReaderFrom
+		}{b, b, b}, b
+	case hijacker | readerFrom:
+		return struct {
+			rw
+			http.Hijacker
+			io.ReaderFrom
+		}{b, b, b}, b
+	case flusher | hijacker | readerFrom:
+		return struct {
+			rw
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{b, b, b, b}, b
+	case pusher:
+		return struct {
+			rw
+			http.Pusher
+		}{b, b}, b
+	case flusher | pusher:
+		return struct {
+			rw
+			http.Flusher
+			http.Pusher
+		}{b, b, b}, b
+	case hijacker | pusher:
+		return struct {
+			rw
+			http.Hijacker
+			http.Pusher
+		}{b, b, b}, b
+	case flusher | hijacker | pusher:
+		return struct {
+			rw
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{b, b, b, b}, b
+	case readerFrom | pusher:
+		return struct {
+			rw
+			io.ReaderFrom
+			http.Pusher
+		}{b, b, b}, b
+	case flusher | readerFrom | pusher:
+		return struct {
+			rw
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{b, b, b, b}, b
+	case hijacker | readerFrom | pusher:
+		return struct {
+			rw
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{b, b, b, b}, b
+	case flusher | hijacker | readerFrom | pusher:
+		return b, b
+	}
+	return struct {
+		rw
+	}{b}, b
+}
+
+func TestResponseWriterInterfaces(t *testing.T) {
+	constructors := map[string]func(http.ResponseWriter) ResponseWriter{
+		"WithoutBody": NewResponseWriterWithoutBody,
+		"WithBody": func(w http.ResponseWriter) ResponseWriter {
+			return NewResponseWriterWithBody(w, 5, CaptureHead)
+		},
+	}
+	for name, newResponseWriter := range constructors {
+		newResponseWriter := newResponseWriter
+		t.Run(name, func(t *testing.T) {
+			for features := 0; features <= flusher|hijacker|readerFrom|pusher; features++ {
+				underlying, base := newTestWriter(features)
+				w := newResponseWriter(underlying)
+				require.Equal(t, underlying, w.Unwrap())
+
+				f, ok := w.(http.Flusher)
+				require.Equal(t, features&flusher != 0, ok, "Flusher %04b", features)
+				if ok {
+					f.Flush()
+					require.Equal(t, 1, base.flushed)
+				}
+
+				h, ok := w.(http.Hijacker)
+				require.Equal(t, features&hijacker != 0, ok, "Hijacker %04b", features)
+				if ok {
+					_, _, err := h.Hijack()
+					require.NoError(t, err)
+					require.Equal(t, 1, base.hijacked)
+				}
+
+				p, ok := w.(http.Pusher)
+				require.Equal(t, features&pusher != 0, ok, "Pusher %04b", features)
+				if ok {
+					require.NoError(t, p.Push("/style.css", nil))
+					require.Equal(t, []string{"/style.css"}, base.pushed)
+				}
+
+				rf, ok := w.(io.ReaderFrom)
+				require.Equal(t, features&readerFrom != 0, ok, "ReaderFrom %04b", features)
+				if ok {
+					n, err := rf.ReadFrom(strings.NewReader("Hello World"))
+					require.NoError(t, err)
+					require.Equal(t, int64(11), n)
+					require.Equal(t, 1, base.readFrom)
+					require.Equal(t, "Hello World", base.body.String())
+					require.Equal(t, 11, w.WrittenBodyBytes())
+					if name == "WithBody" {
+						require.Equal(t, "Hello", string(w.Body()))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResponseWriterRelease(t *testing.T) {
+	constructors := map[string]func(http.ResponseWriter) ResponseWriter{
+		"WithoutBody": NewResponseWriterWithoutBody,
+		"WithBody": func(w http.ResponseWriter) ResponseWriter {
+			return NewResponseWriterWithBody(w, 5, CaptureHead)
+		},
+	}
+	for name, newResponseWriter := range constructors {
+		newResponseWriter := newResponseWriter
+		t.Run(name, func(t *testing.T) {
+			// a released writer is reused for writers with other features
+			for _, features := range []int{flusher, flusher, hijacker | pusher, 0, flusher} {
+				underlying, base := newTestWriter(features)
+				w := newResponseWriter(underlying)
+				require.Equal(t, underlying, w.Unwrap())
+				require.Equal(t, 0, w.WrittenBodyBytes())
+				require.False(t, w.HeaderWritten())
+				require.Empty(t, w.Body())
+				_, ok := w.GetCustomMetric("key")
+				require.False(t, ok)
+
+				_, isFlusher := w.(http.Flusher)
+				require.Equal(t, features&flusher != 0, isFlusher, "Flusher %04b", features)
+				_, isPusher := w.(http.Pusher)
+				require.Equal(t, features&pusher != 0, isPusher, "Pusher %04b", features)
+
+				w.WriteHeader(http.StatusTeapot)
+				_, err := io.WriteString(w, "Hello World")
+				require.NoError(t, err)
+				w.SetCustomMetric("key", "value")
+				require.Equal(t, "Hello World", base.body.String())
+				w.Release()
+			}
+		})
+	}
+}
+
+func TestResponseWriterResponseController(t *testing.T) {
+	underlying, base := newTestWriter(flusher)
+	w := NewResponseWriterWithoutBody(underlying)
+
+	rc := http.NewResponseController(w)
+	require.NoError(t, rc.Flush())
+	require.Equal(t, 1, base.flushed)
+	require.True(t, errors.Is(rc.EnableFullDuplex(), http.ErrNotSupported))
+}
+
+func TestResponseWriterStatusCode(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       func(w ResponseWriter)
+		code          int
+		headerWritten bool
+		superfluous   int
+		hijacked      bool
+	}{
+		{
+			name:    "nothing written",
+			handler: func(ResponseWriter) {},
+			code:    http.StatusOK,
+		},
+		{
+			name: "implicit header on write",
+			handler: func(w ResponseWriter) {
+				_, _ = w.Write([]byte("Hello World"))
+			},
+			code:          http.StatusOK,
+			headerWritten: true,
+		},
+		{
+			name: "implicit header on flush",
+			handler: func(w ResponseWriter) {
+				w.(http.Flusher).Flush()
+				w.WriteHeader(http.StatusNotFound)
+			},
+			code:          http.StatusOK,
+			headerWritten: true,
+			superfluous:   1,
+		},
+		{
+			name: "implicit header on read from",
+			handler: func(w ResponseWriter) {
+				_, _ = w.(io.ReaderFrom).ReadFrom(strings.NewReader("Hello World"))
+			},
+			code:          http.StatusOK,
+			headerWritten: true,
+		},
+		{
+			name: "explicit header",
+			handler: func(w ResponseWriter) {
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("Hello World"))
+			},
+			code:          http.StatusCreated,
+			headerWritten: true,
+		},
+		{
+			name: "first explicit header wins",
+			handler: func(w ResponseWriter) {
+				w.WriteHeader(http.StatusNotFound)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(http.StatusOK)
+			},
+			code:          http.StatusNotFound,
+			headerWritten: true,
+			superfluous:   2,
+		},
+		{
+			name: "superfluous after write",
+			handler: func(w ResponseWriter) {
+				_, _ = w.Write([]byte("Hello World"))
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			code:          http.StatusOK,
+			headerWritten: true,
+			superfluous:   1,
+		},
+		{
+			name: "informational header",
+			handler: func(w ResponseWriter) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusAccepted)
+			},
+			code:          http.StatusAccepted,
+			headerWritten: true,
+		},
+		{
+			name: "switching protocols",
+			handler: func(w ResponseWriter) {
+				w.WriteHeader(http.StatusSwitchingProtocols)
+			},
+			code:          http.StatusSwitchingProtocols,
+			headerWritten: true,
+		},
+		{
+			name: "hijacked",
+			handler: func(w ResponseWriter) {
+				_, _, _ = w.(http.Hijacker).Hijack()
+				w.WriteHeader(http.StatusOK)
+			},
+			code:     0,
+			hijacked: true,
+		},
+	}
+
+	for _, test := range tests {
+		underlying, _ := newTestWriter(flusher | hijacker | readerFrom)
+		w := NewResponseWriterWithBody(underlying, 1024, CaptureHead)
+		test.handler(w)
+		require.Equal(t, test.code, w.StatusCode(), test.name)
+		require.Equal(t, test.headerWritten, w.HeaderWritten(), test.name)
+		require.Equal(t, test.superfluous, w.SuperfluousWriteHeaders(), test.name)
+		require.Equal(t, test.hijacked, w.Hijacked(), test.name)
+	}
+}
+
+func TestResponseWriterTimestamps(t *testing.T) {
+	underlying, _ := newTestWriter(0)
+	w := NewResponseWriterWithoutBody(underlying)
+	require.True(t, w.HeaderWrittenAt().IsZero())
+	require.True(t, w.FirstByteWrittenAt().IsZero())
+
+	w.WriteHeader(http.StatusOK)
+	headerAt := w.HeaderWrittenAt()
+	require.False(t, headerAt.IsZero())
+	require.True(t, w.FirstByteWrittenAt().IsZero())
+
+	// empty writes do not count as first byte
+	_, _ = w.Write(nil)
+	require.True(t, w.FirstByteWrittenAt().IsZero())
+
+	time.Sleep(time.Millisecond)
+	_, _ = w.Write([]byte("Hello"))
+	firstByteAt := w.FirstByteWrittenAt()
+	require.True(t, firstByteAt.After(headerAt))
+
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = w.Write([]byte(" World"))
+	require.Equal(t, headerAt, w.HeaderWrittenAt())
+	require.Equal(t, firstByteAt, w.FirstByteWrittenAt())
+}
+
+// slowReader returns its chunks with a delay in between
+type slowReader struct {
+	chunks []string
+	delay  time.Duration
+	read   bool
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if r.read {
+		time.Sleep(r.delay)
+	}
+	r.read = true
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestResponseWriterReadFromTimestamps(t *testing.T) {
+	for _, withBody := range []bool{false, true} {
+		underlying, _ := newTestWriter(readerFrom)
+		w := NewResponseWriterWithoutBody(underlying)
+		if withBody {
+			w = NewResponseWriterWithBody(underlying, 1024, CaptureHead)
+		}
+		n, err := w.(io.ReaderFrom).ReadFrom(&slowReader{chunks: []string{"Hello", " World"}, delay: 20 * time.Millisecond})
+		end := time.Now()
+		require.NoError(t, err)
+		require.Equal(t, int64(11), n)
+		require.Equal(t, 11, w.WrittenBodyBytes())
+
+		// the first byte is recorded when it was read, not when the transfer ended
+		require.False(t, w.FirstByteWrittenAt().IsZero())
+		require.True(t, end.Sub(w.FirstByteWrittenAt()) >= 20*time.Millisecond, "%v", end.Sub(w.FirstByteWrittenAt()))
+		w.Release()
+	}
+}
+
+type failingWriter struct {
+	baseWriter
+	errs []error
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	err := w.errs[0]
+	w.errs = w.errs[1:]
+	if err != nil {
+		return 0, err
+	}
+	return w.baseWriter.Write(b)
+}
+
+func TestResponseWriterWriteError(t *testing.T) {
+	first := errors.New("first")
+	underlying := &failingWriter{
+		baseWriter: baseWriter{header: make(http.Header)},
+		errs:       []error{nil, first, errors.New("second")},
+	}
+	w := NewResponseWriterWithBody(underlying, 1024, CaptureHead)
+
+	_, err := w.Write([]byte("Hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteError())
+
+	_, err = w.Write([]byte("World"))
+	require.Equal(t, first, err)
+	_, err = w.Write([]byte("World"))
+	require.Error(t, err)
+
+	require.Equal(t, first, w.WriteError())
+	require.Equal(t, 5, w.WrittenBodyBytes())
+}
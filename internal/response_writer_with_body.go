@@ -3,11 +3,12 @@ package internal
 import (
 	"io"
 	"net/http"
+	"sync"
 )
 
 type responseWriterWithBody struct {
 	responseWriterWithoutBody
-	body LimitedBuffer
+	body Buffer
 }
 
 func (rw *responseWriterWithBody) Write(b []byte) (int, error) {
@@ -22,17 +23,40 @@ func (rw *responseWriterWithBody) Write(b []byte) (int, error) {
 	return rw.responseWriterWithoutBody.Write(b)
 }
 
+// ReadFrom collects the body while passing it to the underlying io.ReaderFrom,
+// note that this prevents optimizations like sendfile
+func (rw *responseWriterWithBody) ReadFrom(src io.Reader) (int64, error) {
+	return rw.responseWriterWithoutBody.ReadFrom(io.TeeReader(src, &rw.body))
+}
+
 func (rw *responseWriterWithBody) Body() []byte {
 	return rw.body.Bytes()
 }
 
-// NewResponseWriterWithBody creates a new ResponseWriter that caches the Body
-func NewResponseWriterWithBody(w http.ResponseWriter, maxSize int) ResponseWriter {
-	r := &responseWriterWithBody{
-		responseWriterWithoutBody: responseWriterWithoutBody{
-			ResponseWriter: w,
-		},
-	}
-	r.body.MaxSize = maxSize
-	return r
+func (rw *responseWriterWithBody) BodyTruncated() bool {
+	return rw.body.Truncated()
+}
+
+func (rw *responseWriterWithBody) ReleaseBody() {
+	rw.body.Release()
+}
+
+func (rw *responseWriterWithBody) Release() {
+	rw.body.Release()
+	rw.reset()
+	responseWriterWithBodyPool.Put(rw)
+}
+
+var responseWriterWithBodyPool = sync.Pool{
+	New: func() interface{} {
+		return new(responseWriterWithBody)
+	},
+}
+
+// NewResponseWriterWithBody creates a new ResponseWriter that caches the part of the Body selected by the mode,
+// it is taken from a pool and must be released once its metrics are no longer used
+func NewResponseWriterWithBody(w http.ResponseWriter, maxSize int, mode CaptureMode) ResponseWriter {
+	rw := responseWriterWithBodyPool.Get().(*responseWriterWithBody)
+	rw.body.SetLimit(mode, maxSize)
+	return rw.use(rw, w)
 }
@@ -1,53 +1,276 @@
 package internal
 
 import (
+	"bufio"
+	"io"
+	"net"
 	"net/http"
+	"os"
 	"sync"
+	"time"
 )
 
 type responseWriterWithoutBody struct {
-	statusCode int
-	written    int
+	statusCode  int
+	wroteHeader bool
+	superfluous int
+	hijacked    bool
+	written     int
+	writeErr    error
+	headerAt    time.Time
+	firstByteAt time.Time
+	// src wraps the reader passed to ReadFrom to record the time of the first byte
+	src firstByteReader
 	http.ResponseWriter
-	customMetrics sync.Map
+
+	// mu guards customMetrics and generation, the map is kept when the writer is released
+	mu            sync.Mutex
+	customMetrics map[interface{}]interface{}
+	generation    uint64
+
+	// wrapped is self wrapped for the features of the underlying http.ResponseWriter,
+	// it is kept when the writer is released, so a reused writer only wraps itself again if the features change
+	wrapped  ResponseWriter
+	features int
+}
+
+var responseWriterPool = sync.Pool{
+	New: func() interface{} {
+		return new(responseWriterWithoutBody)
+	},
 }
 
 func (rw *responseWriterWithoutBody) Write(b []byte) (int, error) {
+	rw.writeImplicitHeader()
 	n, err := rw.ResponseWriter.Write(b)
 	if n > 0 {
-		rw.written += n
+		rw.wroteBytes(n)
 	}
+	rw.setWriteError(err)
 	return n, err
 }
 
 func (rw *responseWriterWithoutBody) WriteHeader(statusCode int) {
-	rw.statusCode = statusCode
+	switch {
+	case rw.hijacked:
+	case rw.wroteHeader:
+		rw.superfluous++
+	case statusCode >= 100 && statusCode <= 199 && statusCode != http.StatusSwitchingProtocols:
+		// informational headers can be sent multiple times before the final header
+	default:
+		rw.statusCode = statusCode
+		rw.wroteHeader = true
+		rw.headerAt = time.Now()
+	}
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
 
+// writeImplicitHeader records the 200 status code that the http.Server sends if the handler did not call WriteHeader
+func (rw *responseWriterWithoutBody) writeImplicitHeader() {
+	if !rw.wroteHeader && !rw.hijacked {
+		rw.statusCode = http.StatusOK
+		rw.wroteHeader = true
+		rw.headerAt = time.Now()
+	}
+}
+
+func (rw *responseWriterWithoutBody) setWriteError(err error) {
+	if err != nil && rw.writeErr == nil {
+		rw.writeErr = err
+	}
+}
+
+func (rw *responseWriterWithoutBody) wroteBytes(n int) {
+	rw.markFirstByte()
+	rw.written += n
+}
+
+func (rw *responseWriterWithoutBody) markFirstByte() {
+	if rw.firstByteAt.IsZero() {
+		rw.firstByteAt = time.Now()
+	}
+}
+
+// ReadFrom passes src to the underlying io.ReaderFrom, so optimizations like sendfile are preserved.
+// The first byte is recorded on the first read of src, a file that might be sent without being read is recorded
+// when the transfer starts.
+func (rw *responseWriterWithoutBody) ReadFrom(src io.Reader) (int64, error) {
+	rw.writeImplicitHeader()
+	if sendfile(src) {
+		rw.markFirstByte()
+	} else {
+		rw.src = firstByteReader{Reader: src, rw: rw}
+		src = &rw.src
+	}
+	n, err := rw.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	rw.src = firstByteReader{}
+	if n > 0 {
+		rw.wroteBytes(int(n))
+	}
+	rw.setWriteError(err)
+	return n, err
+}
+
+// firstByteReader records the time of the first byte that was read
+type firstByteReader struct {
+	io.Reader
+	rw *responseWriterWithoutBody
+}
+
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.rw.markFirstByte()
+	}
+	return n, err
+}
+
+// sendfile reports whether src is a file that the net.TCPConn might send without reading it
+func sendfile(src io.Reader) bool {
+	if lr, ok := src.(*io.LimitedReader); ok {
+		src = lr.R
+	}
+	_, ok := src.(*os.File)
+	return ok
+}
+
+func (rw *responseWriterWithoutBody) Flush() {
+	rw.writeImplicitHeader()
+	rw.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (rw *responseWriterWithoutBody) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := rw.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		rw.hijacked = true
+	}
+	return conn, buf, err
+}
+
+func (rw *responseWriterWithoutBody) Push(target string, opts *http.PushOptions) error {
+	return rw.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+func (rw *responseWriterWithoutBody) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// StatusCode returns the status code the client received,
+// if no header was written this is the 200 that will be sent after the handler returned
 func (rw *responseWriterWithoutBody) StatusCode() int {
+	if !rw.wroteHeader && !rw.hijacked {
+		return http.StatusOK
+	}
 	return rw.statusCode
 }
 
+func (rw *responseWriterWithoutBody) HeaderWritten() bool {
+	return rw.wroteHeader
+}
+
+func (rw *responseWriterWithoutBody) SuperfluousWriteHeaders() int {
+	return rw.superfluous
+}
+
+func (rw *responseWriterWithoutBody) HeaderWrittenAt() time.Time {
+	return rw.headerAt
+}
+
+func (rw *responseWriterWithoutBody) FirstByteWrittenAt() time.Time {
+	return rw.firstByteAt
+}
+
+func (rw *responseWriterWithoutBody) Hijacked() bool {
+	return rw.hijacked
+}
+
 func (rw *responseWriterWithoutBody) Body() []byte {
 	return nil
 }
 
+func (rw *responseWriterWithoutBody) BodyTruncated() bool {
+	return false
+}
+
+func (rw *responseWriterWithoutBody) ReleaseBody() {}
+
 func (rw *responseWriterWithoutBody) WrittenBodyBytes() int {
 	return rw.written
 }
 
+func (rw *responseWriterWithoutBody) WriteError() error {
+	return rw.writeErr
+}
+
 func (rw *responseWriterWithoutBody) SetCustomMetric(key, value interface{}) {
-	rw.customMetrics.Store(key, value)
+	rw.mu.Lock()
+	if rw.customMetrics == nil {
+		rw.customMetrics = make(map[interface{}]interface{})
+	}
+	rw.customMetrics[key] = value
+	rw.mu.Unlock()
 }
 
 func (rw *responseWriterWithoutBody) GetCustomMetric(key interface{}) (interface{}, bool) {
-	return rw.customMetrics.Load(key)
+	rw.mu.Lock()
+	value, ok := rw.customMetrics[key]
+	rw.mu.Unlock()
+	return value, ok
 }
 
-// NewResponseWriterWithoutBody creates a new ResponseWriter that skipts the body
-func NewResponseWriterWithoutBody(w http.ResponseWriter) ResponseWriter {
-	return &responseWriterWithoutBody{
-		ResponseWriter: w,
+func (rw *responseWriterWithoutBody) Generation() uint64 {
+	rw.mu.Lock()
+	generation := rw.generation
+	rw.mu.Unlock()
+	return generation
+}
+
+func (rw *responseWriterWithoutBody) GetCustomMetricOf(generation uint64, key interface{}) (interface{}, bool) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.generation != generation {
+		return nil, false
 	}
+	value, ok := rw.customMetrics[key]
+	return value, ok
+}
+
+func (rw *responseWriterWithoutBody) Release() {
+	rw.reset()
+	responseWriterPool.Put(rw)
+}
+
+// reset clears the collected metrics and the underlying http.ResponseWriter
+func (rw *responseWriterWithoutBody) reset() {
+	rw.statusCode = 0
+	rw.wroteHeader = false
+	rw.superfluous = 0
+	rw.hijacked = false
+	rw.written = 0
+	rw.writeErr = nil
+	rw.headerAt = time.Time{}
+	rw.firstByteAt = time.Time{}
+	rw.ResponseWriter = nil
+	rw.mu.Lock()
+	clear(rw.customMetrics)
+	rw.generation++
+	rw.mu.Unlock()
+}
+
+// use sets the underlying http.ResponseWriter and returns self wrapped for its optional interfaces
+func (rw *responseWriterWithoutBody) use(self writer, w http.ResponseWriter) ResponseWriter {
+	rw.ResponseWriter = w
+	features := writerFeatures(w)
+	if rw.wrapped == nil || rw.features != features {
+		rw.wrapped = wrap(self, features)
+		rw.features = features
+	}
+	return rw.wrapped
+}
+
+// NewResponseWriterWithoutBody creates a new ResponseWriter that skipts the body,
+// it is taken from a pool and must be released once its metrics are no longer used
+func NewResponseWriterWithoutBody(w http.ResponseWriter) ResponseWriter {
+	rw := responseWriterPool.Get().(*responseWriterWithoutBody)
+	return rw.use(rw, w)
 }
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"slices"
+)
+
+// RingBuffer keeps the last MaxSize bytes that were written to it
+type RingBuffer struct {
+	MaxSize int
+	// buf holds the kept bytes, it grows up to MaxSize and once it is full start is the index of the oldest byte
+	buf     []byte
+	start   int
+	written int
+	// pooled is reused to return the storage to the pool without allocating
+	pooled *[]byte
+}
+
+// Write writes a byte slice to the buffer, overwriting the oldest bytes if it is full
+func (b *RingBuffer) Write(p []byte) (int, error) {
+	size := len(p)
+	b.written += size
+	if b.MaxSize <= 0 || size == 0 {
+		return size, nil
+	}
+	b.acquire()
+
+	if size >= b.MaxSize {
+		// only the end of p is kept
+		b.buf = b.buf[:0]
+		b.grow(b.MaxSize)
+		b.buf = append(b.buf, p[size-b.MaxSize:]...)
+		b.start = 0
+		return size, nil
+	}
+	if free := b.MaxSize - len(b.buf); free > 0 {
+		n := min(free, len(p))
+		b.grow(n)
+		b.buf = append(b.buf, p[:n]...)
+		p = p[n:]
+	}
+	for len(p) > 0 {
+		n := copy(b.buf[b.start:], p)
+		p = p[n:]
+		b.start = (b.start + n) % b.MaxSize
+	}
+	return size, nil
+}
+
+// Bytes returns the kept bytes in the order they were written
+func (b *RingBuffer) Bytes() []byte {
+	if b.start > 0 {
+		// rotate the oldest byte to the front
+		slices.Reverse(b.buf[:b.start])
+		slices.Reverse(b.buf[b.start:])
+		slices.Reverse(b.buf)
+		b.start = 0
+	}
+	return b.buf
+}
+
+// Written returns the count of all bytes that were written to the buffer
+func (b *RingBuffer) Written() int {
+	return b.written
+}
+
+// Truncated reports whether older bytes were dropped because of MaxSize,
+// a buffer without a positive MaxSize collects nothing and is never truncated
+func (b *RingBuffer) Truncated() bool {
+	return b.MaxSize > 0 && b.written > b.MaxSize
+}
+
+// acquire uses storage from the pool for an empty buffer
+func (b *RingBuffer) acquire() {
+	if b.buf != nil {
+		return
+	}
+	if pooled, ok := bufferPool.Get().(*[]byte); ok {
+		b.buf = (*pooled)[:0]
+		b.pooled = pooled
+	}
+}
+
+// grow makes room to append n bytes, the storage grows with the written bytes up to MaxSize,
+// so a small body does not allocate MaxSize bytes
+func (b *RingBuffer) grow(n int) {
+	if len(b.buf)+n <= cap(b.buf) {
+		return
+	}
+	buf := make([]byte, len(b.buf), min(max(2*cap(b.buf), len(b.buf)+n, 512), b.MaxSize))
+	copy(buf, b.buf)
+	b.buf = buf
+}
+
+// Release resets the buffer and returns its storage to a pool,
+// the bytes returned by Bytes must not be used afterwards
+func (b *RingBuffer) Release() {
+	p := b.buf[:0]
+	if cap(p) > 0 && cap(p) <= maxPooledBufferSize {
+		if b.pooled == nil {
+			b.pooled = new([]byte)
+		}
+		*b.pooled = p
+		bufferPool.Put(b.pooled)
+	}
+	b.buf = nil
+	b.start = 0
+	b.written = 0
+	b.pooled = nil
+}
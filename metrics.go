@@ -1,7 +1,13 @@
 package httpmetrics
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/talon-one/go-httpmetrics/internal"
@@ -9,11 +15,39 @@ import (
 
 // Metrics holds the collected metrics
 type Metrics struct {
+	// Start is the time the handler was called
+	Start time.Time
 	// Duration is the time it took to execute the handler.
-	Duration       time.Duration
+	Duration time.Duration
+	// TimeToHeader is the time from Start until the response header was written, it is 0 if the handler wrote no header
+	TimeToHeader time.Duration
+	// TimeToFirstByte is the time from Start until the first body byte was written, it is 0 if the handler wrote no body
+	TimeToFirstByte time.Duration
+	// Route is the low cardinality name of the route that collected the request:
+	// the Collect pattern, "*" for the default handler or the MetricsRequest.Route set by the CustomRouter
+	Route string
+	// Pattern is the Collect pattern that matched the request, it is empty for the default (*) and custom routers
+	Pattern string
+	// PathValues holds the values of the wildcards in Pattern
+	PathValues map[string]string
+	// Panic is the value the Handler panicked with, see CollectOptions.OnPanic
+	Panic interface{}
+	// PanicStack is the stack trace of the panic
+	PanicStack []byte
+	// Error is the error of a request sent by the Transport, returned by the RoundTrip or while reading the
+	// response body
+	Error error
+	// Sampling is the sampling decision of the request, see CollectOptions.Sampler
+	Sampling Decision
+	// Client holds the connection timings of a request sent by the Transport, it is nil for served requests
+	Client         *ClientTimings
 	Request        Request
 	Response       Response
 	responseWriter internal.ResponseWriter
+	// generation is the generation of the responseWriter, so a kept Metrics does not read the custom metrics of
+	// another request that reuses the responseWriter
+	generation  uint64
+	requestBody *internal.RequestBodyReader
 }
 
 // Header is a dummy function for fulfilling the http.Handler interface
@@ -29,33 +63,111 @@ func (Metrics) Write(b []byte) (int, error) {
 // WriteHeader is a dummy function for fulfilling the http.Handler interface
 func (Metrics) WriteHeader(int) {}
 
-// GetCustomMetric can be used to get a custom metric value
+// PathValue returns the value of the named wildcard in Pattern, or "" if there is no such wildcard
+func (m Metrics) PathValue(name string) string {
+	return m.PathValues[name]
+}
+
+// GetCustomMetric can be used to get a custom metric value,
+// it reports no values once the MetricsFunc returned
 func (m Metrics) GetCustomMetric(key interface{}) (interface{}, bool) {
-	return m.responseWriter.GetCustomMetric(key)
+	if m.responseWriter == nil {
+		return nil, false
+	}
+	return m.responseWriter.GetCustomMetricOf(m.generation, key)
+}
+
+// release returns the writer, the reader and their body buffers to their pools
+func (m *Metrics) release() {
+	if m.responseWriter != nil {
+		m.responseWriter.Release()
+	}
+	if m.requestBody != nil {
+		m.requestBody.Release()
+	}
+}
+
+// Clone returns a copy of the Metrics that can be used after the MetricsFunc returned, the bodies are copied and
+// GetCustomMetric of the copy reports no values
+func (m Metrics) Clone() Metrics {
+	m.Request.Body = bytes.Clone(m.Request.Body)
+	m.Response.Body = bytes.Clone(m.Response.Body)
+	m.responseWriter = nil
+	m.requestBody = nil
+	return m
+}
+
+// ClientAborted reports whether the client went away before the response was written,
+// either because the request context was canceled or because writing the response failed with a disconnect error
+func (m Metrics) ClientAborted() bool {
+	if m.Request.Canceled {
+		return true
+	}
+	err := m.Response.WriteError
+	return errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, net.ErrClosed)
 }
 
-// Request extends the http.Request that was sent with Body and BodySize
+// Request extends the http.Request that was sent with Body and BodySize,
+// the declared size of the body is the ContentLength of the http.Request
 type Request struct {
 	*http.Request
 	Body              []byte
 	ConsumedBodyBytes int
+	// Truncated reports whether the read body exceeded CollectRequestBody, Body holds only the part selected by
+	// CollectOptions.CaptureMode. The read body includes the bytes that were collected or drained after the Handler
+	// returned, a larger body that was read only in part by the Handler and not drained is not reported.
+	Truncated bool
+	// TotalBodyBytes is the size of the whole body, or -1 if the body was not read until its end,
+	// see CollectOptions.DrainRequestBody
+	TotalBodyBytes int
+	// ReadError is the first error returned while reading the body, by the Handler or after it returned,
+	// e.g. because the client went away or the CollectOptions.ReadBodyTimeout was exceeded
+	ReadError error
+	// Canceled reports whether the request context was canceled before the handler returned,
+	// this happens if the client closed the connection
+	Canceled bool
 }
 
 // Response contains the http response that has been sent to the client
 type Response struct {
-	Code             int
+	// Code is the status code the client received, this is 200 if the handler never called WriteHeader
+	Code int
+	// HeaderWritten reports whether the handler wrote the header, explicitly or implicitly by writing the body
+	HeaderWritten bool
+	// SuperfluousWriteHeaders is the count of WriteHeader calls that were ignored because the header was already written
+	SuperfluousWriteHeaders int
+	// Hijacked reports whether the handler hijacked the connection, Code is 0 if no header was written before
+	Hijacked         bool
 	Body             []byte
 	WrittenBodyBytes int
-	Header           http.Header
+	// Truncated reports whether the written body exceeded CollectResponseBody,
+	// Body holds only the part selected by CollectOptions.CaptureMode
+	Truncated bool
+	// ContentLength is the value of the Content-Length header, or -1 if it is not set
+	ContentLength int64
+	Header        http.Header
+	// WriteError is the first error returned while writing the response body
+	WriteError error
+	// Complete reports whether the response was fully written: there was no write error or panic, the connection was
+	// not hijacked and the written body matches the Content-Length header if set.
+	// Note that the response is buffered, so a client that went away might only be visible in Request.Canceled.
+	Complete bool
 }
 
-// MetricsFunc is used for the callback registered by Collect
+// MetricsFunc is used for the callback registered by Collect.
+// The bodies and custom metrics of the Metrics are only valid until the MetricsFunc returned, afterwards their buffers
+// are reused for other requests. Use Metrics.Clone to keep them.
 type MetricsFunc func(Metrics)
 
 // MetricsRequest will be passed to the CustomRouter, set the Collect fields to enable collection of this Request
 type MetricsRequest struct {
 	*CollectOptions
 	Collect bool
+	// Route sets the Metrics.Route of the collected request
+	Route string
 }
 
 // Header is a dummy function for fulfilling the http.Handler interface
@@ -85,3 +197,16 @@ func GetCustomMetric(w http.ResponseWriter, key interface{}) (interface{}, bool)
 	}
 	return nil, false
 }
+
+// contentLength returns the value of the Content-Length header, or -1 if it is not set or invalid
+func contentLength(h http.Header) int64 {
+	v := h.Get("Content-Length")
+	if v == "" {
+		return -1
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
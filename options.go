@@ -0,0 +1,124 @@
+package httpmetrics
+
+import (
+	"net/http"
+	"time"
+)
+
+// CaptureMode selects the part of a body that is collected, see CollectOptions.CaptureMode
+type CaptureMode int
+
+const (
+	// CaptureHead collects the first bytes of a body
+	CaptureHead CaptureMode = iota
+	// CaptureTail collects the last bytes of a body, e.g. the end of a streamed JSON array or an error trailer
+	CaptureTail
+	// CaptureHeadTail collects the first and the last half of the bytes of a body,
+	// if bytes in between were not collected they are replaced by a marker like "\n...[42 bytes elided]...\n"
+	CaptureHeadTail
+)
+
+// RetainFunc decides whether the collected bodies of a request are retained, see CollectOptions.RetainBodies
+type RetainFunc func(Metrics) bool
+
+// RetainErrors returns a RetainFunc that retains the bodies of requests that failed with a status code of at least
+// minCode, panicked or took at least slowerThan, a zero slowerThan disables the duration check
+func RetainErrors(minCode int, slowerThan time.Duration) RetainFunc {
+	return func(m Metrics) bool {
+		return m.Response.Code >= minCode || m.Panic != nil || (slowerThan > 0 && m.Duration >= slowerThan)
+	}
+}
+
+// merge applies the non-zero fields of the registration options in registration order,
+// the options are only copied if they are modified
+func (options *CollectOptions) merge(registrations []*Registration) *CollectOptions {
+	merged := options
+	for _, registration := range registrations {
+		if registration.options != nil {
+			if merged == options {
+				c := *options
+				merged = &c
+			}
+			merged.override(registration.options)
+		}
+	}
+	// a negative size disables the collection of a body
+	if merged.CollectResponseBody < 0 || merged.CollectRequestBody < 0 {
+		if merged == options {
+			c := *options
+			merged = &c
+		}
+		merged.CollectResponseBody = max(merged.CollectResponseBody, 0)
+		merged.CollectRequestBody = max(merged.CollectRequestBody, 0)
+	}
+	return merged
+}
+
+func (options *CollectOptions) override(override *CollectOptions) {
+	if override.CollectResponseBody != 0 {
+		options.CollectResponseBody = override.CollectResponseBody
+	}
+	if override.CollectRequestBody != 0 {
+		options.CollectRequestBody = override.CollectRequestBody
+	}
+	if override.CaptureMode != CaptureHead {
+		options.CaptureMode = override.CaptureMode
+	}
+	if override.DrainRequestBody != 0 {
+		options.DrainRequestBody = override.DrainRequestBody
+	}
+	if override.ReadBodyTimeout != 0 {
+		options.ReadBodyTimeout = override.ReadBodyTimeout
+	}
+	if len(override.CollectRequestHeaders) > 0 {
+		options.CollectRequestHeaders = override.CollectRequestHeaders
+	}
+	if len(override.CollectResponseHeaders) > 0 {
+		options.CollectResponseHeaders = override.CollectResponseHeaders
+	}
+	if override.SampleRate != 0 {
+		// a route specific rate replaces a global Sampler
+		options.SampleRate = override.SampleRate
+		options.Sampler = nil
+	}
+	if override.Sampler != nil {
+		options.Sampler = override.Sampler
+	}
+	if override.Redaction != nil {
+		options.Redaction = override.Redaction
+	}
+	if override.OnPanic != PanicIgnore {
+		options.OnPanic = override.OnPanic
+	}
+	if override.RetainBodies != nil {
+		options.RetainBodies = override.RetainBodies
+	}
+	if override.IncludeDefault {
+		options.IncludeDefault = true
+	}
+}
+
+// sample returns the sampling decision for the request and the ResponseSampler if the decision can change after
+// the response was written
+func (options *CollectOptions) sample(r *http.Request, route string) (Decision, ResponseSampler) {
+	if options.Sampler != nil {
+		responseSampler, _ := options.Sampler.(ResponseSampler)
+		return options.Sampler.Sample(r, route), responseSampler
+	}
+	if options.SampleRate == 0 {
+		return Decision{Sample: true, Rate: 1}, nil
+	}
+	return probabilitySampler(options.SampleRate).Sample(r, route), nil
+}
+
+// filterHeader returns a copy of the header that only contains the listed names
+func filterHeader(h http.Header, names []string) http.Header {
+	filtered := make(http.Header, len(names))
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if values, ok := h[name]; ok {
+			filtered[name] = append([]string(nil), values...)
+		}
+	}
+	return filtered
+}
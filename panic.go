@@ -0,0 +1,41 @@
+package httpmetrics
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicMode controls how the Collector handles panics of the Handler
+type PanicMode int
+
+const (
+	// PanicIgnore does not intercept panics, no Metrics are collected for the request
+	PanicIgnore PanicMode = iota
+	// PanicRecover recovers the panic and responds with 500 Internal Server Error if nothing was written yet
+	PanicRecover
+	// PanicRepanic observes the panic and panics again after the Metrics were delivered,
+	// so the http.Server aborts the response
+	PanicRepanic
+)
+
+// serveHandler calls the Handler and records a panic in the Metrics
+func serveHandler(options *CollectOptions, metrics *Metrics, r *http.Request) {
+	if options.OnPanic != PanicIgnore {
+		defer func() {
+			if err := recover(); err != nil {
+				metrics.Panic = err
+				metrics.PanicStack = debug.Stack()
+			}
+		}()
+	}
+	options.Handler.ServeHTTP(metrics.responseWriter, r)
+}
+
+// repanic reports whether the recorded panic must be passed on to the http.Server,
+// http.ErrAbortHandler is always passed on because it is used to abort the response
+func repanic(options *CollectOptions, metrics *Metrics) bool {
+	if metrics.Panic == nil {
+		return false
+	}
+	return options.OnPanic == PanicRepanic || metrics.Panic == http.ErrAbortHandler
+}
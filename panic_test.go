@@ -0,0 +1,99 @@
+package httpmetrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+func panicCollector(mode httpmetrics.PanicMode, handler http.HandlerFunc) (*httpmetrics.Collector, *[]httpmetrics.Metrics) {
+	var collected []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: handler,
+		OnPanic: mode,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		collected = append(collected, m)
+	})
+	return collector, &collected
+}
+
+func TestPanicRecover(t *testing.T) {
+	t.Run("nothing written", func(t *testing.T) {
+		collector, collected := panicCollector(httpmetrics.PanicRecover, func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		})
+		rec := httptest.NewRecorder()
+		require.NotPanics(t, func() {
+			collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		require.Len(t, *collected, 1)
+		m := (*collected)[0]
+		require.Equal(t, "boom", m.Panic)
+		require.Contains(t, string(m.PanicStack), "panic_test.go")
+		require.Equal(t, http.StatusInternalServerError, m.Response.Code)
+	})
+
+	t.Run("header written", func(t *testing.T) {
+		collector, collected := panicCollector(httpmetrics.PanicRecover, func(w http.ResponseWriter, _ *http.Request) {
+			io.WriteString(w, "Hello")
+			panic("boom")
+		})
+		rec := httptest.NewRecorder()
+		collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "Hello", rec.Body.String())
+		require.Len(t, *collected, 1)
+		require.Equal(t, "boom", (*collected)[0].Panic)
+		require.Equal(t, http.StatusOK, (*collected)[0].Response.Code)
+	})
+
+	t.Run("abort handler", func(t *testing.T) {
+		collector, collected := panicCollector(httpmetrics.PanicRecover, func(http.ResponseWriter, *http.Request) {
+			panic(http.ErrAbortHandler)
+		})
+		require.Panics(t, func() {
+			collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+		require.Len(t, *collected, 1)
+		require.Equal(t, http.ErrAbortHandler, (*collected)[0].Panic)
+		require.Equal(t, http.StatusInternalServerError, (*collected)[0].Response.Code)
+	})
+}
+
+func TestPanicRepanic(t *testing.T) {
+	collector, collected := panicCollector(httpmetrics.PanicRepanic, func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+	require.Panics(t, func() {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	require.Len(t, *collected, 1)
+	require.Equal(t, "boom", (*collected)[0].Panic)
+	require.Equal(t, http.StatusInternalServerError, (*collected)[0].Response.Code)
+	require.False(t, (*collected)[0].Response.HeaderWritten)
+}
+
+func TestPanicIgnore(t *testing.T) {
+	collector, collected := panicCollector(httpmetrics.PanicIgnore, func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+	require.Panics(t, func() {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	require.Len(t, *collected, 0)
+}
+
+func TestNoPanic(t *testing.T) {
+	collector, collected := panicCollector(httpmetrics.PanicRecover, func(http.ResponseWriter, *http.Request) {})
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Len(t, *collected, 1)
+	require.Nil(t, (*collected)[0].Panic)
+	require.Nil(t, (*collected)[0].PanicStack)
+	require.Equal(t, http.StatusOK, (*collected)[0].Response.Code)
+}
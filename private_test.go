@@ -1,3 +1,6 @@
+// Collect patterns require the Go 1.22 ServeMux, which is not the default when building without a go.mod
+//go:debug httpmuxgo121=0
+
 package httpmetrics
 
 import (
@@ -10,7 +13,7 @@ import (
 func TestShouldCollectInvalidRequests(t *testing.T) {
 	t.Run("nil request", func(t *testing.T) {
 		collector := New(CollectOptions{})
-		h, opts := collector.shouldCollect(nil)
+		h, opts, _ := collector.shouldCollect(nil)
 		require.Nil(t, h)
 		require.Nil(t, opts)
 	})
@@ -19,8 +22,160 @@ func TestShouldCollectInvalidRequests(t *testing.T) {
 		req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", nil)
 		require.NoError(t, err)
 		req.URL = nil
-		h, opts := collector.shouldCollect(req)
+		h, opts, _ := collector.shouldCollect(req)
 		require.Nil(t, h)
 		require.Nil(t, opts)
 	})
 }
+
+func TestShouldCollectPatterns(t *testing.T) {
+	collector := New(CollectOptions{})
+	for _, p := range []string{
+		"/users/{id}",
+		"/users/me",
+		"GET /users/{id}/posts",
+		"/static/",
+		"/files/{path...}",
+		"/exact/{$}",
+		"example.com/host",
+		"legacy",
+	} {
+		p := p
+		collector.Collect(func(Metrics) {}, p)
+	}
+
+	tests := []struct {
+		method     string
+		url        string
+		pattern    string
+		pathValues map[string]string
+	}{
+		{http.MethodGet, "http://127.0.0.1/users/42", "/users/{id}", map[string]string{"id": "42"}},
+		{http.MethodGet, "http://127.0.0.1/users/me", "/users/me", nil},
+		{http.MethodGet, "http://127.0.0.1/users/42/posts", "GET /users/{id}/posts", map[string]string{"id": "42"}},
+		{http.MethodHead, "http://127.0.0.1/users/42/posts", "GET /users/{id}/posts", map[string]string{"id": "42"}},
+		{http.MethodPost, "http://127.0.0.1/users/42/posts", "", nil},
+		{http.MethodGet, "http://127.0.0.1/static/css/main.css", "/static/", nil},
+		{http.MethodGet, "http://127.0.0.1/files/a/b/c.txt", "/files/{path...}", map[string]string{"path": "a/b/c.txt"}},
+		{http.MethodGet, "http://127.0.0.1/exact/", "/exact/{$}", nil},
+		{http.MethodGet, "http://127.0.0.1/exact/more", "", nil},
+		{http.MethodGet, "http://example.com/host", "example.com/host", nil},
+		{http.MethodGet, "http://127.0.0.1/host", "", nil},
+		{http.MethodGet, "http://127.0.0.1/legacy", "/legacy", nil},
+		{http.MethodGet, "http://127.0.0.1/unknown", "", nil},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, nil)
+		require.NoError(t, err)
+		h, opts, match := collector.shouldCollect(req)
+		require.Equal(t, test.pattern, match.pattern, test.url)
+		require.Equal(t, test.pattern, match.route, test.url)
+		require.Equal(t, test.pathValues, match.pathValues, test.url)
+		if test.pattern == "" {
+			require.Nil(t, h, test.url)
+			require.Nil(t, opts, test.url)
+		} else {
+			require.NotNil(t, h, test.url)
+			require.NotNil(t, opts, test.url)
+		}
+		// the request passed to the handler must not be modified
+		require.Empty(t, req.Pattern)
+	}
+}
+
+func TestCollectInvalidPattern(t *testing.T) {
+	collector := New(CollectOptions{})
+	collector.Collect(func(Metrics) {}, "/a/{x}")
+	require.Panics(t, func() {
+		collector.Collect(func(Metrics) {}, "/b", "/{y}/b", "/a/{x")
+	})
+
+	// the previous registration is still intact
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1/b", nil)
+	require.NoError(t, err)
+	h, _, _ := collector.shouldCollect(req)
+	require.Nil(t, h)
+
+	req, err = http.NewRequest(http.MethodGet, "http://127.0.0.1/a/1", nil)
+	require.NoError(t, err)
+	h, _, _ = collector.shouldCollect(req)
+	require.NotNil(t, h)
+}
+
+func TestCleanPattern(t *testing.T) {
+	tests := map[string]string{
+		"":                       "*",
+		"*":                      "*",
+		"test":                   "/test",
+		"users/list":             "/users/list",
+		"GET users/{id}":         "GET /users/{id}",
+		"localhost:8080/a":       "localhost:8080/a",
+		"/test":                  "/test",
+		"/test/":                 "/test/",
+		"/a/../b/":               "/b/",
+		"//a//b":                 "/a/b",
+		"GET /users/{id}":        "GET /users/{id}",
+		"POST  example.com/a/./": "POST example.com/a/",
+		"/":                      "/",
+	}
+	for in, out := range tests {
+		require.Equal(t, out, cleanPattern(in), in)
+	}
+}
+
+func TestLowerPattern(t *testing.T) {
+	tests := map[string]string{
+		"*":                         "*",
+		"/Test/":                    "/test/",
+		"GET /Users/{ID}/{Rest...}": "GET /users/{ID}/{Rest...}",
+		"Example.com/A":             "Example.com/a",
+	}
+	for in, out := range tests {
+		require.Equal(t, out, lowerPattern(in), in)
+	}
+}
+
+func TestShouldCollectRoute(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1/users/42", nil)
+	require.NoError(t, err)
+
+	t.Run("default handler", func(t *testing.T) {
+		collector := New(CollectOptions{})
+		collector.Collect(func(Metrics) {})
+		_, _, match := collector.shouldCollect(req)
+		require.Equal(t, "*", match.route)
+		require.Empty(t, match.pattern)
+	})
+
+	t.Run("custom router", func(t *testing.T) {
+		collector := New(CollectOptions{
+			CustomRouter: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if m, ok := w.(*MetricsRequest); ok {
+					m.Collect = true
+					m.Route = "users"
+				}
+			}),
+		})
+		_, _, match := collector.shouldCollect(req)
+		require.Equal(t, "users", match.route)
+		require.Empty(t, match.pattern)
+	})
+}
+
+func TestP2Quantile(t *testing.T) {
+	e := newP2Quantile(0.5)
+	require.Equal(t, 0.0, e.value())
+	for _, v := range []float64{3, 1, 2} {
+		e.add(v)
+	}
+	require.Equal(t, 2.0, e.value())
+
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		e := newP2Quantile(p)
+		// a permutation of 0..9999
+		for i := 0; i < 10000; i++ {
+			e.add(float64(i * 7919 % 10000))
+		}
+		require.InDelta(t, p*10000, e.value(), 100, "p%v", p)
+	}
+}
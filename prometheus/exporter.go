@@ -0,0 +1,205 @@
+// Package prometheus exports httpmetrics.Metrics in the Prometheus text exposition format
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/talon-one/go-httpmetrics"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultDurationBuckets are the default buckets (in seconds) of the request duration histogram
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the default buckets (in bytes) of the request and response size histograms
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+// Options controls the behavior of the Exporter
+type Options struct {
+	// Namespace is prepended to all metric names, separated by an underscore
+	Namespace string
+	// DurationBuckets are the upper bounds (in seconds) of the request duration histogram buckets
+	DurationBuckets []float64
+	// SizeBuckets are the upper bounds (in bytes) of the request and response size histogram buckets
+	SizeBuckets []float64
+	// Route returns the route label for the metrics, defaults to httpmetrics.Metrics.Route
+	Route func(httpmetrics.Metrics) string
+}
+
+// Exporter collects httpmetrics.Metrics and serves them in the Prometheus text exposition format
+type Exporter struct {
+	options Options
+
+	mu     sync.Mutex
+	series map[labels]*series
+}
+
+type labels struct {
+	route  string
+	method string
+	code   string
+}
+
+type series struct {
+	requests     float64
+	duration     histogram
+	requestSize  histogram
+	responseSize histogram
+}
+
+func (s *series) clone() *series {
+	return &series{
+		requests:     s.requests,
+		duration:     s.duration.clone(),
+		requestSize:  s.requestSize.clone(),
+		responseSize: s.responseSize.clone(),
+	}
+}
+
+// New creates a new Exporter
+func New(options Options) *Exporter {
+	if len(options.DurationBuckets) == 0 {
+		options.DurationBuckets = DefaultDurationBuckets
+	}
+	if len(options.SizeBuckets) == 0 {
+		options.SizeBuckets = DefaultSizeBuckets
+	}
+	if options.Route == nil {
+		options.Route = defaultRoute
+	}
+	options.DurationBuckets = sortedBuckets(options.DurationBuckets)
+	options.SizeBuckets = sortedBuckets(options.SizeBuckets)
+	return &Exporter{
+		options: options,
+		series:  make(map[labels]*series),
+	}
+}
+
+// Register registers the Exporter on the collector for the specified paths, see httpmetrics.Collector.Collect
+func (e *Exporter) Register(collector *httpmetrics.Collector, paths ...string) *httpmetrics.Registration {
+	return collector.Collect(e.Observe, paths...)
+}
+
+// Observe records the metrics of a request, it can be used as a httpmetrics.MetricsFunc.
+// Sampled requests are weighted by httpmetrics.Decision.Weight.
+func (e *Exporter) Observe(m httpmetrics.Metrics) {
+	l := labels{
+		route:  e.options.Route(m),
+		code:   strconv.Itoa(m.Response.Code),
+		method: method(m.Request.Method),
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s, ok := e.series[l]
+	if !ok {
+		s = &series{
+			duration:     newHistogram(e.options.DurationBuckets),
+			requestSize:  newHistogram(e.options.SizeBuckets),
+			responseSize: newHistogram(e.options.SizeBuckets),
+		}
+		e.series[l] = s
+	}
+	// a sampled request represents multiple requests
+	weight := m.Sampling.Weight()
+	s.requests += weight
+	s.duration.observe(m.Duration.Seconds(), weight)
+	s.requestSize.observe(float64(requestSize(m)), weight)
+	s.responseSize.observe(float64(m.Response.WrittenBodyBytes), weight)
+}
+
+// ServeHTTP writes all collected series in the Prometheus text exposition format
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = e.WriteTo(w)
+}
+
+// WriteTo writes all collected series in the Prometheus text exposition format to w
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	// the series are copied, so a slow writer does not block Observe
+	e.mu.Lock()
+	keys := make([]labels, 0, len(e.series))
+	snapshot := make(map[labels]*series, len(e.series))
+	for l, s := range e.series {
+		keys = append(keys, l)
+		snapshot[l] = s.clone()
+	}
+	e.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	name := e.name("http_requests_total")
+	writeHeader(cw, name, "counter", "Total number of HTTP requests.")
+	for _, l := range keys {
+		writeSample(cw, name, l, "", "", snapshot[l].requests)
+	}
+
+	writeHistograms(cw, e.name("http_request_duration_seconds"), "Duration of HTTP requests in seconds.", keys, snapshot, func(s *series) *histogram { return &s.duration })
+	writeHistograms(cw, e.name("http_request_size_bytes"), "Size of HTTP request bodies in bytes.", keys, snapshot, func(s *series) *histogram { return &s.requestSize })
+	writeHistograms(cw, e.name("http_response_size_bytes"), "Size of HTTP response bodies in bytes.", keys, snapshot, func(s *series) *histogram { return &s.responseSize })
+
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+func (e *Exporter) name(name string) string {
+	if e.options.Namespace == "" {
+		return name
+	}
+	return e.options.Namespace + "_" + name
+}
+
+func defaultRoute(m httpmetrics.Metrics) string {
+	return m.Route
+}
+
+// method returns the method label, non standard methods are reported as OTHER, so clients cannot create
+// an unbounded count of series
+func method(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+func requestSize(m httpmetrics.Metrics) int64 {
+	// the whole body is only known if it was read until its end, e.g. by draining the part the handler did not read
+	if m.Request.TotalBodyBytes >= 0 {
+		return int64(m.Request.TotalBodyBytes)
+	}
+	if m.Request.ConsumedBodyBytes > 0 {
+		return int64(m.Request.ConsumedBodyBytes)
+	}
+	if m.Request.Request != nil && m.Request.ContentLength > 0 {
+		return m.Request.ContentLength
+	}
+	return 0
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	return b
+}
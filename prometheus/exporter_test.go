@@ -0,0 +1,209 @@
+package prometheus_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+	"github.com/talon-one/go-httpmetrics/prometheus"
+)
+
+func scrape(t *testing.T, exporter *prometheus.Exporter) string {
+	rec := httptest.NewRecorder()
+	exporter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, prometheus.ContentType, rec.Header().Get("Content-Type"))
+	return rec.Body.String()
+}
+
+func TestExporterWithCollector(t *testing.T) {
+	exporter := prometheus.New(prometheus.Options{})
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, "Hello World")
+		}),
+	})
+	exporter.Register(collector)
+
+	s := httptest.NewServer(collector)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		res, err := s.Client().Post(s.URL+"/test", "text/plain", strings.NewReader("12345"))
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+
+	out := scrape(t, exporter)
+	require.Contains(t, out, "# TYPE http_requests_total counter\n")
+	require.Contains(t, out, `http_requests_total{code="201",method="POST",route="*"} 3`+"\n")
+	require.Contains(t, out, "# TYPE http_request_duration_seconds histogram\n")
+	require.Contains(t, out, `http_request_duration_seconds_bucket{code="201",method="POST",route="*",le="+Inf"} 3`+"\n")
+	require.Contains(t, out, `http_request_duration_seconds_count{code="201",method="POST",route="*"} 3`+"\n")
+	require.Contains(t, out, `http_request_size_bytes_bucket{code="201",method="POST",route="*",le="100"} 3`+"\n")
+	require.Contains(t, out, `http_request_size_bytes_sum{code="201",method="POST",route="*"} 15`+"\n")
+	require.Contains(t, out, `http_response_size_bytes_sum{code="201",method="POST",route="*"} 33`+"\n")
+}
+
+func TestExporterHistogramBuckets(t *testing.T) {
+	exporter := prometheus.New(prometheus.Options{
+		Namespace:       "app",
+		DurationBuckets: []float64{1, 0.1},
+		Route: func(httpmetrics.Metrics) string {
+			return "fixed"
+		},
+	})
+
+	for _, d := range []time.Duration{50 * time.Millisecond, 500 * time.Millisecond, 5 * time.Second} {
+		var m httpmetrics.Metrics
+		m.Request.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		m.Response.Code = http.StatusOK
+		m.Duration = d
+		exporter.Observe(m)
+	}
+
+	var buf bytes.Buffer
+	n, err := exporter.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+
+	out := buf.String()
+	require.Contains(t, out, `app_http_request_duration_seconds_bucket{code="200",method="GET",route="fixed",le="0.1"} 1`+"\n")
+	require.Contains(t, out, `app_http_request_duration_seconds_bucket{code="200",method="GET",route="fixed",le="1"} 2`+"\n")
+	require.Contains(t, out, `app_http_request_duration_seconds_bucket{code="200",method="GET",route="fixed",le="+Inf"} 3`+"\n")
+	require.Contains(t, out, `app_http_request_duration_seconds_sum{code="200",method="GET",route="fixed"} 5.55`+"\n")
+}
+
+func TestExporterRequestSize(t *testing.T) {
+	exporter := prometheus.New(prometheus.Options{})
+	for _, sizes := range [][2]int{{23, 0}, {-1, 4}} {
+		var m httpmetrics.Metrics
+		m.Request.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		m.Request.ContentLength = -1
+		m.Request.TotalBodyBytes, m.Request.ConsumedBodyBytes = sizes[0], sizes[1]
+		m.Response.Code = http.StatusOK
+		exporter.Observe(m)
+	}
+
+	// the drained size of an unread body is preferred over the consumed size
+	require.Contains(t, scrape(t, exporter), `http_request_size_bytes_sum{code="200",method="POST",route=""} 27`+"\n")
+}
+
+func TestExporterNonStandardMethods(t *testing.T) {
+	exporter := prometheus.New(prometheus.Options{})
+	for _, method := range []string{"FOO", "BAR", http.MethodPut} {
+		var m httpmetrics.Metrics
+		m.Request.Request = httptest.NewRequest(method, "/", nil)
+		m.Response.Code = http.StatusOK
+		exporter.Observe(m)
+	}
+
+	out := scrape(t, exporter)
+	require.Contains(t, out, `http_requests_total{code="200",method="OTHER",route=""} 2`+"\n")
+	require.Contains(t, out, `http_requests_total{code="200",method="PUT",route=""} 1`+"\n")
+	require.NotContains(t, out, "FOO")
+}
+
+func TestExporterEscapesLabelValues(t *testing.T) {
+	exporter := prometheus.New(prometheus.Options{
+		Route: func(httpmetrics.Metrics) string {
+			return "a\"b\\c\nd"
+		},
+	})
+	var m httpmetrics.Metrics
+	m.Request.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	exporter.Observe(m)
+
+	require.Contains(t, scrape(t, exporter), `http_requests_total{code="0",method="GET",route="a\"b\\c\nd"} 1`+"\n")
+}
+
+func TestExporterNoSeries(t *testing.T) {
+	exporter := prometheus.New(prometheus.Options{})
+	require.Equal(t, `# HELP http_requests_total Total number of HTTP requests.
+# TYPE http_requests_total counter
+# HELP http_request_duration_seconds Duration of HTTP requests in seconds.
+# TYPE http_request_duration_seconds histogram
+# HELP http_request_size_bytes Size of HTTP request bodies in bytes.
+# TYPE http_request_size_bytes histogram
+# HELP http_response_size_bytes Size of HTTP response bodies in bytes.
+# TYPE http_response_size_bytes histogram
+`, scrape(t, exporter))
+}
+
+func TestExporterSampleWeight(t *testing.T) {
+	exporter := prometheus.New(prometheus.Options{})
+	for _, rate := range []float64{0.5, 0.25, 0} {
+		var m httpmetrics.Metrics
+		m.Request.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		m.Response.Code = http.StatusOK
+		m.Response.WrittenBodyBytes = 10
+		m.Sampling = httpmetrics.Decision{Sample: true, Rate: rate}
+		exporter.Observe(m)
+	}
+
+	out := scrape(t, exporter)
+	require.Contains(t, out, `http_requests_total{code="200",method="GET",route=""} 7`+"\n")
+	require.Contains(t, out, `http_response_size_bytes_bucket{code="200",method="GET",route="",le="100"} 7`+"\n")
+	require.Contains(t, out, `http_response_size_bytes_sum{code="200",method="GET",route=""} 70`+"\n")
+}
+
+// blockingWriter blocks the first Write until release is closed
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.started:
+	default:
+		close(w.started)
+		<-w.release
+	}
+	return len(p), nil
+}
+
+func TestExporterSlowWriterDoesNotBlockObserve(t *testing.T) {
+	exporter := prometheus.New(prometheus.Options{})
+	observe := func(route string) {
+		var m httpmetrics.Metrics
+		m.Request.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		m.Route = route
+		exporter.Observe(m)
+	}
+	// enough series to exceed the write buffer
+	for i := 0; i < 100; i++ {
+		observe(strconv.Itoa(i))
+	}
+
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		_, _ = exporter.WriteTo(w)
+		close(done)
+	}()
+	<-w.started
+
+	observed := make(chan struct{})
+	go func() {
+		observe("new")
+		close(observed)
+	}()
+	select {
+	case <-observed:
+	case <-time.After(time.Second):
+		require.FailNow(t, "Observe was blocked by the writer")
+	}
+	close(w.release)
+	<-done
+}
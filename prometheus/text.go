@@ -0,0 +1,113 @@
+package prometheus
+
+import (
+	"bufio"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type histogram struct {
+	upperBounds []float64
+	// counts holds the non cumulative count per bucket, the last element is the +Inf bucket
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+func newHistogram(upperBounds []float64) histogram {
+	return histogram{
+		upperBounds: upperBounds,
+		counts:      make([]float64, len(upperBounds)+1),
+	}
+}
+
+// observe records a value, weight is the count of requests the value represents
+func (h *histogram) observe(v, weight float64) {
+	i := len(h.upperBounds)
+	for j, bound := range h.upperBounds {
+		if v <= bound {
+			i = j
+			break
+		}
+	}
+	h.counts[i] += weight
+	h.sum += v * weight
+	h.count += weight
+}
+
+// clone returns a copy of the histogram, the upper bounds are shared as they are never modified
+func (h histogram) clone() histogram {
+	h.counts = append([]float64(nil), h.counts...)
+	return h
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) write(s string) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.WriteString(s)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+func writeHeader(cw *countingWriter, name, typ, help string) {
+	cw.write("# HELP " + name + " " + help + "\n")
+	cw.write("# TYPE " + name + " " + typ + "\n")
+}
+
+func writeHistograms(cw *countingWriter, name, help string, keys []labels, series map[labels]*series, get func(*series) *histogram) {
+	writeHeader(cw, name, "histogram", help)
+	for _, l := range keys {
+		h := get(series[l])
+		var cumulative float64
+		for i, bound := range h.upperBounds {
+			cumulative += h.counts[i]
+			writeSample(cw, name+"_bucket", l, "le", formatFloat(bound), cumulative)
+		}
+		writeSample(cw, name+"_bucket", l, "le", "+Inf", h.count)
+		writeSample(cw, name+"_sum", l, "", "", h.sum)
+		writeSample(cw, name+"_count", l, "", "", h.count)
+	}
+}
+
+func writeSample(cw *countingWriter, name string, l labels, extraName, extraValue string, value float64) {
+	cw.write(name)
+	cw.write(`{code="`)
+	cw.write(escapeLabelValue(l.code))
+	cw.write(`",method="`)
+	cw.write(escapeLabelValue(l.method))
+	cw.write(`",route="`)
+	cw.write(escapeLabelValue(l.route))
+	cw.write(`"`)
+	if extraName != "" {
+		cw.write("," + extraName + `="` + escapeLabelValue(extraValue) + `"`)
+	}
+	cw.write("} ")
+	cw.write(formatFloat(value))
+	cw.write("\n")
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
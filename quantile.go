@@ -0,0 +1,105 @@
+package httpmetrics
+
+import (
+	"math"
+	"sort"
+)
+
+// p2Quantile estimates a quantile of a stream with constant memory using the P² algorithm
+// by Jain and Chlamtac
+type p2Quantile struct {
+	p     float64
+	count int
+	// heights of the markers
+	q [5]float64
+	// actual and desired positions of the markers, and the increments of the desired positions
+	n  [5]float64
+	np [5]float64
+	dn [5]float64
+}
+
+func newP2Quantile(p float64) *p2Quantile {
+	return &p2Quantile{
+		p:  p,
+		dn: [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+func (e *p2Quantile) add(x float64) {
+	if e.count < 5 {
+		e.q[e.count] = x
+		e.count++
+		if e.count == 5 {
+			sort.Float64s(e.q[:])
+			e.n = [5]float64{1, 2, 3, 4, 5}
+			e.np = [5]float64{1, 1 + 2*e.p, 1 + 4*e.p, 3 + 2*e.p, 5}
+		}
+		return
+	}
+	e.count++
+
+	var k int
+	switch {
+	case x < e.q[0]:
+		e.q[0] = x
+		k = 0
+	case x >= e.q[4]:
+		e.q[4] = x
+		k = 3
+	default:
+		for k = 0; k < 3; k++ {
+			if x < e.q[k+1] {
+				break
+			}
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		e.n[i]++
+	}
+	for i := range e.np {
+		e.np[i] += e.dn[i]
+	}
+
+	// adjust the heights of the middle markers if they are off their desired position
+	for i := 1; i <= 3; i++ {
+		d := e.np[i] - e.n[i]
+		if (d >= 1 && e.n[i+1]-e.n[i] > 1) || (d <= -1 && e.n[i-1]-e.n[i] < -1) {
+			d = math.Copysign(1, d)
+			q := e.parabolic(i, d)
+			if e.q[i-1] >= q || q >= e.q[i+1] {
+				q = e.linear(i, d)
+			}
+			e.q[i] = q
+			e.n[i] += d
+		}
+	}
+}
+
+func (e *p2Quantile) parabolic(i int, d float64) float64 {
+	return e.q[i] + d/(e.n[i+1]-e.n[i-1])*
+		((e.n[i]-e.n[i-1]+d)*(e.q[i+1]-e.q[i])/(e.n[i+1]-e.n[i])+
+			(e.n[i+1]-e.n[i]-d)*(e.q[i]-e.q[i-1])/(e.n[i]-e.n[i-1]))
+}
+
+func (e *p2Quantile) linear(i int, d float64) float64 {
+	j := i + int(d)
+	return e.q[i] + d*(e.q[j]-e.q[i])/(e.n[j]-e.n[i])
+}
+
+// value returns the current estimate, it is exact for less than 5 observations
+func (e *p2Quantile) value() float64 {
+	if e.count == 0 {
+		return 0
+	}
+	if e.count < 5 {
+		q := make([]float64, e.count)
+		copy(q, e.q[:e.count])
+		sort.Float64s(q)
+		i := int(math.Ceil(e.p*float64(e.count))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return q[i]
+	}
+	return e.q[2]
+}
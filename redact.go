@@ -0,0 +1,234 @@
+package httpmetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// DefaultMask is the value that replaces redacted data if Redaction.Mask is not set
+const DefaultMask = "[REDACTED]"
+
+// SensitiveHeaders lists common headers that contain credentials, it can be used for Redaction.DenyHeaders
+var SensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var (
+	// CardNumberScrubber matches payment card numbers, optionally separated by spaces or dashes
+	CardNumberScrubber = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	// EmailScrubber matches email addresses
+	EmailScrubber = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+)
+
+// Redaction masks sensitive data in the Metrics before they are passed to a MetricsFunc or the CustomRouter
+type Redaction struct {
+	// AllowHeaders lists the request and response headers that are kept, all other headers are masked
+	AllowHeaders []string
+	// DenyHeaders lists the request and response headers that are masked
+	DenyHeaders []string
+	// JSONFields lists the fields that are masked in application/json bodies.
+	// Fields are dot separated paths, * matches any key and arrays are traversed implicitly,
+	// e.g. "password", "user.token" or "cards.*.number".
+	// Bodies that cannot be parsed (e.g. because they were truncated or hold more than one value) are masked completely.
+	JSONFields []string
+	// FormFields lists the fields that are masked in application/x-www-form-urlencoded bodies, in the query of the
+	// request URL and in the form values the Handler parsed into the request
+	FormFields []string
+	// Scrubbers replace all their matches in the bodies, e.g. CardNumberScrubber or EmailScrubber
+	Scrubbers []*regexp.Regexp
+	// Mask replaces the redacted data, defaults to DefaultMask
+	Mask string
+}
+
+func (redaction *Redaction) mask() string {
+	if redaction.Mask == "" {
+		return DefaultMask
+	}
+	return redaction.Mask
+}
+
+// apply redacts the metrics, the request, its header, URL and form values and the response header are copied,
+// so the originals stay untouched
+func (redaction *Redaction) apply(metrics *Metrics) {
+	if metrics.Request.Request != nil {
+		req := *metrics.Request.Request
+		req.Header = redaction.header(req.Header)
+		redaction.request(&req)
+		metrics.Request.Request = &req
+		metrics.Request.Body = redaction.body(metrics.Request.Body, req.Header.Get("Content-Type"))
+	}
+	metrics.Response.Header = redaction.header(metrics.Response.Header)
+	metrics.Response.Body = redaction.body(metrics.Response.Body, metrics.Response.Header.Get("Content-Type"))
+}
+
+// request masks the FormFields in the query and the parsed form values of the copied request
+func (redaction *Redaction) request(req *http.Request) {
+	if len(redaction.FormFields) == 0 {
+		return
+	}
+	if req.URL != nil && req.URL.RawQuery != "" {
+		if query, ok := redaction.values(req.URL.Query()); ok {
+			u := *req.URL
+			u.RawQuery = query.Encode()
+			req.URL = &u
+			if req.RequestURI != "" {
+				req.RequestURI = u.RequestURI()
+			}
+		}
+	}
+	req.Form, _ = redaction.values(req.Form)
+	req.PostForm, _ = redaction.values(req.PostForm)
+	if req.MultipartForm != nil {
+		if value, ok := redaction.values(req.MultipartForm.Value); ok {
+			form := *req.MultipartForm
+			form.Value = value
+			req.MultipartForm = &form
+		}
+	}
+}
+
+// values returns a copy of the values with the FormFields masked and reports whether a field was masked,
+// the values are returned unchanged if they hold none of the fields
+func (redaction *Redaction) values(values url.Values) (url.Values, bool) {
+	var masked url.Values
+	for _, field := range redaction.FormFields {
+		if v, ok := values[field]; ok {
+			if masked == nil {
+				masked = maps.Clone(values)
+			}
+			masked[field] = redaction.masks(len(v))
+		}
+	}
+	if masked == nil {
+		return values, false
+	}
+	return masked, true
+}
+
+// masks returns n masks to replace n values
+func (redaction *Redaction) masks(n int) []string {
+	masked := make([]string, n)
+	for i := range masked {
+		masked[i] = redaction.mask()
+	}
+	return masked
+}
+
+func (redaction *Redaction) header(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	h = h.Clone()
+	for name, values := range h {
+		if redaction.maskHeader(name) {
+			h[name] = redaction.masks(len(values))
+		}
+	}
+	return h
+}
+
+func (redaction *Redaction) maskHeader(name string) bool {
+	if len(redaction.AllowHeaders) > 0 && !containsFold(redaction.AllowHeaders, name) {
+		return true
+	}
+	return containsFold(redaction.DenyHeaders, name)
+}
+
+func (redaction *Redaction) body(body []byte, contentType string) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case len(redaction.JSONFields) > 0 && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")):
+		body = redaction.json(body)
+	case len(redaction.FormFields) > 0 && mediaType == "application/x-www-form-urlencoded":
+		body = redaction.form(body)
+	}
+	for _, scrubber := range redaction.Scrubbers {
+		body = scrubber.ReplaceAll(body, []byte(redaction.mask()))
+	}
+	return body
+}
+
+func (redaction *Redaction) json(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return []byte(redaction.mask())
+	}
+	// trailing data, e.g. a second value, would neither be checked nor encoded again
+	if len(bytes.TrimSpace(body[decoder.InputOffset():])) > 0 {
+		return []byte(redaction.mask())
+	}
+
+	masked := false
+	for _, field := range redaction.JSONFields {
+		if maskJSON(v, strings.Split(field, "."), redaction.mask()) {
+			masked = true
+		}
+	}
+	if !masked {
+		return body
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return []byte(redaction.mask())
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// maskJSON replaces the values at path in v and reports whether anything was masked
+func maskJSON(v interface{}, path []string, mask string) bool {
+	masked := false
+	switch t := v.(type) {
+	case []interface{}:
+		for _, e := range t {
+			if maskJSON(e, path, mask) {
+				masked = true
+			}
+		}
+	case map[string]interface{}:
+		for k, e := range t {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				t[k] = mask
+				masked = true
+			} else if maskJSON(e, path[1:], mask) {
+				masked = true
+			}
+		}
+	}
+	return masked
+}
+
+func (redaction *Redaction) form(body []byte) []byte {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return []byte(redaction.mask())
+	}
+	values, masked := redaction.values(values)
+	if !masked {
+		return body
+	}
+	return []byte(values.Encode())
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package httpmetrics_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+func redact(redaction *httpmetrics.Redaction, req *http.Request, handler http.HandlerFunc) httpmetrics.Metrics {
+	var collected httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = ioutil.ReadAll(r.Body)
+			handler(w, r)
+		}),
+		CollectRequestBody:  1024,
+		CollectResponseBody: 1024,
+		Redaction:           redaction,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		collected = m.Clone()
+	})
+	collector.ServeHTTP(httptest.NewRecorder(), req)
+	return collected
+}
+
+func TestRedactHeaders(t *testing.T) {
+	handler := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Request-Id", "1")
+	}
+
+	t.Run("deny", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Add("Cookie", "a=1")
+		req.Header.Add("Cookie", "b=2")
+		req.Header.Set("User-Agent", "test")
+
+		m := redact(&httpmetrics.Redaction{DenyHeaders: httpmetrics.SensitiveHeaders}, req, handler)
+		require.Equal(t, []string{"[REDACTED]"}, m.Request.Header["Authorization"])
+		require.Equal(t, []string{"[REDACTED]", "[REDACTED]"}, m.Request.Header["Cookie"])
+		require.Equal(t, "test", m.Request.Header.Get("User-Agent"))
+		require.Equal(t, "[REDACTED]", m.Response.Header.Get("Set-Cookie"))
+		require.Equal(t, "1", m.Response.Header.Get("X-Request-Id"))
+
+		// the original request is not modified
+		require.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("User-Agent", "test")
+
+		m := redact(&httpmetrics.Redaction{AllowHeaders: []string{"user-agent", "x-request-id"}, Mask: "***"}, req, handler)
+		require.Equal(t, "***", m.Request.Header.Get("Authorization"))
+		require.Equal(t, "test", m.Request.Header.Get("User-Agent"))
+		require.Equal(t, "***", m.Response.Header.Get("Set-Cookie"))
+		require.Equal(t, "1", m.Response.Header.Get("X-Request-Id"))
+	})
+}
+
+func TestRedactJSON(t *testing.T) {
+	redaction := &httpmetrics.Redaction{
+		JSONFields: []string{"password", "user.token", "cards.*"},
+	}
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "fields",
+			body:     `{"name":"<b>","password":"secret","user":{"token":"abc","id":1.50},"cards":[{"number":"4111"},{"cvc":123}]}`,
+			expected: `{"cards":[{"number":"[REDACTED]"},{"cvc":"[REDACTED]"}],"name":"<b>","password":"[REDACTED]","user":{"id":1.50,"token":"[REDACTED]"}}`,
+		},
+		{
+			name:     "nothing to mask",
+			body:     `{ "b": 1, "a": 2 }`,
+			expected: `{ "b": 1, "a": 2 }`,
+		},
+		{
+			name:     "array",
+			body:     `[{"password":"a"},{"password":"b"}]`,
+			expected: `[{"password":"[REDACTED]"},{"password":"[REDACTED]"}]`,
+		},
+		{
+			name:     "invalid",
+			body:     `{"password":"sec`,
+			expected: `[REDACTED]`,
+		},
+		{
+			name:     "trailing value",
+			body:     `{"a":1} {"password":"secret"}`,
+			expected: `[REDACTED]`,
+		},
+		{
+			name:     "trailing data",
+			body:     `{"password":"secret"}]`,
+			expected: `[REDACTED]`,
+		},
+		{
+			name:     "trailing whitespace",
+			body:     "{\"a\":1}\n",
+			expected: "{\"a\":1}\n",
+		},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		m := redact(redaction, req, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/problem+json")
+			io.WriteString(w, test.body)
+		})
+		require.Equal(t, test.expected, string(m.Request.Body), test.name)
+		require.Equal(t, test.expected, string(m.Response.Body), test.name)
+	}
+}
+
+func TestRedactForm(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("user=bob&password=secret&password=again"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	m := redact(&httpmetrics.Redaction{FormFields: []string{"password"}, Mask: "x"}, req, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "password=secret")
+	})
+	require.Equal(t, "password=x&password=x&user=bob", string(m.Request.Body))
+	require.Equal(t, "password=secret", string(m.Response.Body))
+}
+
+func TestRedactFormValues(t *testing.T) {
+	var collected httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(_ http.ResponseWriter, r *http.Request) {
+			_ = r.FormValue("password")
+		}),
+		CollectRequestBody: 1024,
+		Redaction:          &httpmetrics.Redaction{FormFields: []string{"password"}},
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		collected = m.Clone()
+	})
+	req := httptest.NewRequest(http.MethodPost, "/login?user=bob&password=query", strings.NewReader("password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	collector.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, "password=%5BREDACTED%5D", string(collected.Request.Body))
+	require.Equal(t, []string{"[REDACTED]"}, collected.Request.PostForm["password"])
+	require.Equal(t, []string{"[REDACTED]", "[REDACTED]"}, collected.Request.Form["password"])
+	require.Equal(t, "bob", collected.Request.Form.Get("user"))
+	require.Equal(t, "password=%5BREDACTED%5D&user=bob", collected.Request.URL.RawQuery)
+	require.Equal(t, "/login?password=%5BREDACTED%5D&user=bob", collected.Request.RequestURI)
+
+	// the original request is not modified
+	require.Equal(t, "secret", req.PostForm.Get("password"))
+	require.Equal(t, "user=bob&password=query", req.URL.RawQuery)
+}
+
+func TestRedactScrubbers(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("card 4111 1111 1111 1111 of jane.doe@example.com"))
+	m := redact(&httpmetrics.Redaction{Scrubbers: []*regexp.Regexp{httpmetrics.CardNumberScrubber, httpmetrics.EmailScrubber}}, req, func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "order 12345 paid with 4111-1111-1111-1111")
+	})
+	require.Equal(t, "card [REDACTED] of [REDACTED]", string(m.Request.Body))
+	require.Equal(t, "order 12345 paid with [REDACTED]", string(m.Response.Body))
+}
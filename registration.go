@@ -0,0 +1,49 @@
+package httpmetrics
+
+import (
+	"net/http"
+)
+
+// Registration is a MetricsFunc registered by Collect or CollectWithOptions
+type Registration struct {
+	collector *Collector
+	handler   http.HandlerFunc
+	// options override the Collector.Options, nil if registered by Collect
+	options  *CollectOptions
+	patterns []string
+}
+
+// Remove unregisters the MetricsFunc from all its paths, calling Remove multiple times has no effect
+func (registration *Registration) Remove() {
+	collector := registration.collector
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	routing := collector.routing.Load()
+	routes := make(map[string][]*Registration, len(routing.routes))
+	for p, registrations := range routing.routes {
+		if kept := withoutRegistration(registrations, registration); len(kept) > 0 {
+			routes[p] = kept
+		}
+	}
+	collector.setRoutes(routes, withoutRegistration(routing.defaultRoutes, registration))
+}
+
+func withoutRegistration(registrations []*Registration, registration *Registration) []*Registration {
+	var kept []*Registration
+	for _, r := range registrations {
+		if r != registration {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
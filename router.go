@@ -0,0 +1,209 @@
+package httpmetrics
+
+import (
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// routingTable is an immutable snapshot of the registrations, changes publish a new routing table
+type routingTable struct {
+	routes         map[string][]*Registration
+	mux            *http.ServeMux
+	defaultRoutes  []*Registration
+	defaultHandler http.Handler
+}
+
+func newRoutingTable(routes map[string][]*Registration, defaultRoutes []*Registration) *routingTable {
+	routing := &routingTable{
+		routes:         routes,
+		defaultRoutes:  defaultRoutes,
+		defaultHandler: registrationHandlers(defaultRoutes),
+	}
+	if len(routes) > 0 {
+		routing.mux = newRouteMux(routes)
+	}
+	return routing
+}
+
+// routeMatch is passed as http.ResponseWriter to the routing http.ServeMux to find the route of a request
+type routeMatch struct {
+	handler       http.Handler
+	registrations []*Registration
+	route         string
+	pattern       string
+	pathValues    map[string]string
+}
+
+// Header is a dummy function for fulfilling the http.ResponseWriter interface
+func (*routeMatch) Header() http.Header {
+	return http.Header(make(map[string][]string))
+}
+
+// Write is a dummy function for fulfilling the http.ResponseWriter interface
+func (*routeMatch) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// WriteHeader is a dummy function for fulfilling the http.ResponseWriter interface
+func (*routeMatch) WriteHeader(int) {}
+
+// patternHandler is registered on the routing http.ServeMux and reports the matched route to the routeMatch
+type patternHandler struct {
+	pattern       string
+	wildcards     []string
+	registrations []*Registration
+	handler       http.Handler
+}
+
+func (h *patternHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	match, ok := w.(*routeMatch)
+	if !ok {
+		return
+	}
+	match.handler = h.handler
+	match.registrations = h.registrations
+	match.pattern = h.pattern
+	if len(h.wildcards) > 0 {
+		match.pathValues = make(map[string]string, len(h.wildcards))
+		for _, name := range h.wildcards {
+			match.pathValues[name] = r.PathValue(name)
+		}
+	}
+}
+
+// matchRoute looks up the pattern of the request in the routing http.ServeMux without modifying the request
+func matchRoute(mux *http.ServeMux, r *http.Request) (routeMatch, bool) {
+	var match routeMatch
+	h, _ := mux.Handler(r)
+	handler, ok := h.(*patternHandler)
+	if !ok {
+		return match, false
+	}
+	match.handler = handler.handler
+	match.registrations = handler.registrations
+	match.route = handler.pattern
+	match.pattern = handler.pattern
+	if len(handler.wildcards) > 0 {
+		// only ServeHTTP sets the path values, it modifies the request, so pass a shallow copy
+		var values routeMatch
+		mux.ServeHTTP(&values, r.WithContext(r.Context()))
+		match.pathValues = values.pathValues
+	}
+	return match, true
+}
+
+func newRouteMux(routes map[string][]*Registration) *http.ServeMux {
+	mux := http.NewServeMux()
+	for pattern, registrations := range routes {
+		mux.Handle(pattern, &patternHandler{
+			pattern:       pattern,
+			wildcards:     patternWildcards(pattern),
+			registrations: registrations,
+			handler:       registrationHandlers(registrations),
+		})
+	}
+	return mux
+}
+
+// handlers calls all handlers in order
+type handlers []http.Handler
+
+func (hs handlers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, h := range hs {
+		h.ServeHTTP(w, r)
+	}
+}
+
+// registrationHandlers returns a handler that calls the MetricsFuncs of the registrations in order
+func registrationHandlers(registrations []*Registration) http.Handler {
+	if len(registrations) == 0 {
+		return nil
+	}
+	if len(registrations) == 1 {
+		return registrations[0].handler
+	}
+	hs := make(handlers, len(registrations))
+	for i, registration := range registrations {
+		hs[i] = registration.handler
+	}
+	return hs
+}
+
+// cleanPattern normalizes a pattern passed to Collect:
+// a missing leading slash is added unless the pattern starts with a host containing a dot or a port,
+// and the path is cleaned while keeping a trailing slash
+func cleanPattern(p string) string {
+	p = strings.TrimSpace(filepath.ToSlash(p))
+	if p == "*" || p == "" {
+		return "*"
+	}
+
+	var method string
+	if i := strings.IndexAny(p, " \t"); i >= 0 {
+		method = p[:i] + " "
+		p = strings.TrimLeft(p[i:], " \t")
+	}
+
+	// a prefix is only a host if it looks like one, otherwise the pattern is a path without leading slash
+	var host string
+	if i := strings.IndexByte(p, '/'); i > 0 && strings.ContainsAny(p[:i], ".:") {
+		host, p = p[:i], p[i:]
+	} else if i != 0 {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return method + host + cleaned
+}
+
+// lowerPattern lowercases the path of a cleaned pattern, the names of its wildcards are kept
+func lowerPattern(pattern string) string {
+	i := strings.IndexByte(pattern, '/')
+	if i < 0 {
+		return pattern
+	}
+	segments := strings.Split(pattern[i:], "/")
+	for j, segment := range segments {
+		if !strings.HasPrefix(segment, "{") {
+			segments[j] = strings.ToLower(segment)
+		}
+	}
+	return pattern[:i] + strings.Join(segments, "/")
+}
+
+// lowerPath returns a shallow copy of the request with a lowercased path for the route lookup,
+// the request is returned unchanged if its path is lowercase
+func lowerPath(r *http.Request) *http.Request {
+	lower := strings.ToLower(r.URL.Path)
+	if lower == r.URL.Path {
+		return r
+	}
+	u := *r.URL
+	u.Path, u.RawPath = lower, ""
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	return r
+}
+
+// patternWildcards returns the names of all wildcards in a pattern
+func patternWildcards(pattern string) []string {
+	if i := strings.IndexByte(pattern, '/'); i >= 0 {
+		pattern = pattern[i:]
+	}
+	var names []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		name := strings.TrimSuffix(segment[1:len(segment)-1], "...")
+		if name != "" && name != "$" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,167 @@
+package httpmetrics
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decision is the result of a Sampler
+type Decision struct {
+	// Sample reports whether the request is collected
+	Sample bool
+	// Rate is the probability with which requests like this one are collected, it is used to reweight counts
+	Rate float64
+}
+
+// Weight returns the count of requests a collected request represents, 1/Rate.
+// It is 1 if Rate was not set, e.g. for Metrics that were not collected by a Collector.
+func (decision Decision) Weight() float64 {
+	if decision.Rate <= 0 {
+		return 1
+	}
+	return 1 / decision.Rate
+}
+
+// Sampler decides whether a request is collected, it is called before the handler and must be safe for
+// concurrent use
+type Sampler interface {
+	Sample(r *http.Request, route string) Decision
+}
+
+// ResponseSampler is a Sampler that can change the decision once the response was written.
+// All requests are collected provisionally and only delivered if SampleResponse decides to.
+type ResponseSampler interface {
+	Sampler
+	SampleResponse(m Metrics, decision Decision) Decision
+}
+
+type probabilitySampler float64
+
+// Probability returns a Sampler that collects each request with the probability rate
+func Probability(rate float64) Sampler {
+	return probabilitySampler(rate)
+}
+
+func (rate probabilitySampler) Sample(*http.Request, string) Decision {
+	if rate >= 1 {
+		return Decision{Sample: true, Rate: 1}
+	}
+	if rate <= 0 {
+		return Decision{}
+	}
+	return Decision{Sample: rand.Float64() < float64(rate), Rate: float64(rate)}
+}
+
+type rateLimitSampler struct {
+	perSecond int
+
+	mu     sync.Mutex
+	routes map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	seen  int
+	taken int
+	// rate is the fraction of requests that were collected in the previous window
+	rate float64
+}
+
+// RateLimit returns a Sampler that collects at most perSecond requests per route and second.
+// The Decision.Rate is estimated from the request rate of the previous second.
+func RateLimit(perSecond int) Sampler {
+	return &rateLimitSampler{
+		perSecond: perSecond,
+		routes:    make(map[string]*rateWindow),
+	}
+}
+
+func (sampler *rateLimitSampler) Sample(_ *http.Request, route string) Decision {
+	now := time.Now()
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	w, ok := sampler.routes[route]
+	if !ok {
+		w = &rateWindow{start: now, rate: 1}
+		sampler.routes[route] = w
+	}
+	if elapsed := now.Sub(w.start); elapsed >= time.Second {
+		w.rate = 1
+		if elapsed < 2*time.Second && w.seen > 0 {
+			w.rate = float64(w.taken) / float64(w.seen)
+		}
+		w.start = now
+		w.seen = 0
+		w.taken = 0
+	}
+	w.seen++
+	if w.taken >= sampler.perSecond {
+		return Decision{Rate: w.rate}
+	}
+	w.taken++
+	return Decision{Sample: true, Rate: w.rate}
+}
+
+type headerHashSampler struct {
+	header string
+	rate   float64
+}
+
+// HeaderHash returns a Sampler that decides deterministically by the hash of a header, e.g. a trace id,
+// so all requests with the same value are either collected or not.
+// For the traceparent header only the trace id is used. Requests without the header are sampled randomly.
+func HeaderHash(header string, rate float64) Sampler {
+	return &headerHashSampler{
+		header: http.CanonicalHeaderKey(header),
+		rate:   rate,
+	}
+}
+
+func (sampler *headerHashSampler) Sample(r *http.Request, route string) Decision {
+	value := r.Header.Get(sampler.header)
+	if value == "" {
+		return probabilitySampler(sampler.rate).Sample(r, route)
+	}
+	if sampler.header == "Traceparent" {
+		// version-traceid-parentid-flags
+		if parts := strings.Split(value, "-"); len(parts) == 4 {
+			value = parts[1]
+		}
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(value))
+	// use the upper 53 bits for a uniform float in [0, 1)
+	return Decision{
+		Sample: float64(h.Sum64()>>11)/(1<<53) < sampler.rate,
+		Rate:   sampler.rate,
+	}
+}
+
+type errorSampler struct {
+	Sampler
+	minCode int
+}
+
+// AlwaysSampleErrors returns a ResponseSampler that collects the requests that are collected by sampler and
+// all requests that failed with a status code of at least minCode or panicked
+func AlwaysSampleErrors(sampler Sampler, minCode int) ResponseSampler {
+	return &errorSampler{
+		Sampler: sampler,
+		minCode: minCode,
+	}
+}
+
+func (sampler *errorSampler) SampleResponse(m Metrics, decision Decision) Decision {
+	if m.Response.Code >= sampler.minCode || m.Panic != nil {
+		// every error is collected, so each one represents only itself
+		return Decision{Sample: true, Rate: 1}
+	}
+	if inner, ok := sampler.Sampler.(ResponseSampler); ok {
+		return inner.SampleResponse(m, decision)
+	}
+	return decision
+}
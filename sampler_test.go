@@ -0,0 +1,97 @@
+package httpmetrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+func TestProbability(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.Equal(t, httpmetrics.Decision{Sample: true, Rate: 1}, httpmetrics.Probability(1).Sample(req, "*"))
+	require.Equal(t, httpmetrics.Decision{}, httpmetrics.Probability(0).Sample(req, "*"))
+
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		d := httpmetrics.Probability(0.5).Sample(req, "*")
+		require.Equal(t, 0.5, d.Rate)
+		require.Equal(t, 2.0, d.Weight())
+		if d.Sample {
+			sampled++
+		}
+	}
+	require.InDelta(t, 5000, sampled, 500)
+	require.Equal(t, 1.0, httpmetrics.Decision{}.Weight())
+}
+
+func TestRateLimit(t *testing.T) {
+	sampler := httpmetrics.RateLimit(2)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, route := range []string{"/a", "/b"} {
+		var sampled int
+		for i := 0; i < 5; i++ {
+			d := sampler.Sample(req, route)
+			require.Equal(t, 1.0, d.Rate)
+			if d.Sample {
+				sampled++
+			}
+		}
+		require.Equal(t, 2, sampled, route)
+	}
+}
+
+func TestHeaderHash(t *testing.T) {
+	sampler := httpmetrics.HeaderHash("traceparent", 0.5)
+	var sampled, notSampled int
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		traceID := []byte("0af7651916cd43dd8448eb211c80319c")
+		traceID[i%32] = "0123456789abcdef"[i%16]
+		traceID[(i+7)%32] = "0123456789abcdef"[(i/16)%16]
+		req.Header.Set("Traceparent", "00-"+string(traceID)+"-b7ad6b7169203331-01")
+		d := sampler.Sample(req, "*")
+		require.Equal(t, 0.5, d.Rate)
+
+		// the same trace with another parent id results in the same decision
+		req.Header.Set("Traceparent", "00-"+string(traceID)+"-00f067aa0ba902b7-01")
+		require.Equal(t, d, sampler.Sample(req, "*"))
+		if d.Sample {
+			sampled++
+		} else {
+			notSampled++
+		}
+	}
+	require.NotZero(t, sampled)
+	require.NotZero(t, notSampled)
+}
+
+func TestAlwaysSampleErrors(t *testing.T) {
+	var metrics []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}),
+		Sampler: httpmetrics.AlwaysSampleErrors(httpmetrics.Probability(0), http.StatusInternalServerError),
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m)
+	})
+	collector.CollectWithOptions(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m)
+	}, httpmetrics.CollectOptions{SampleRate: 1}, "/all")
+
+	for _, path := range []string{"/ok", "/fail", "/ok", "/all"} {
+		rec := httptest.NewRecorder()
+		collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	require.Len(t, metrics, 2)
+	require.Equal(t, "/fail", metrics[0].Request.URL.Path)
+	require.Equal(t, httpmetrics.Decision{Sample: true, Rate: 1}, metrics[0].Sampling)
+	require.Equal(t, "/all", metrics[1].Request.URL.Path)
+	require.Equal(t, httpmetrics.Decision{Sample: true, Rate: 1}, metrics[1].Sampling)
+}
@@ -0,0 +1,104 @@
+package httpmetrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// LogValue implements slog.LogValuer, the metrics are logged as
+// http.request.*, http.response.* and duration attributes
+func (m Metrics) LogValue() slog.Value {
+	request := make([]slog.Attr, 0, 8)
+	if r := m.Request.Request; r != nil {
+		request = append(request,
+			slog.String("method", r.Method),
+			slog.String("host", r.Host),
+			slog.String("path", r.URL.Path),
+			slog.String("query", r.URL.RawQuery),
+			slog.String("proto", r.Proto),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+	}
+	request = append(request,
+		slog.String("route", m.Route),
+		slog.Int("body_bytes", m.Request.ConsumedBodyBytes),
+	)
+	if m.Request.Canceled {
+		request = append(request, slog.Bool("canceled", true))
+	}
+
+	response := []slog.Attr{
+		slog.Int("status_code", m.Response.Code),
+		slog.Int("body_bytes", m.Response.WrittenBodyBytes),
+		slog.Bool("complete", m.Response.Complete),
+	}
+	if m.Response.Hijacked {
+		response = append(response, slog.Bool("hijacked", true))
+	}
+	if m.Response.WriteError != nil {
+		response = append(response, slog.String("write_error", m.Response.WriteError.Error()))
+	}
+
+	attrs := []slog.Attr{
+		{Key: "http", Value: slog.GroupValue(
+			slog.Attr{Key: "request", Value: slog.GroupValue(request...)},
+			slog.Attr{Key: "response", Value: slog.GroupValue(response...)},
+		)},
+		slog.Duration("duration", m.Duration),
+	}
+	if m.Panic != nil {
+		attrs = append(attrs, slog.Any("panic", m.Panic))
+	}
+	if m.Error != nil {
+		attrs = append(attrs, slog.String("error", m.Error.Error()))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// LogOptions controls the behavior of LogFunc
+type LogOptions struct {
+	// Message is the log message, defaults to "http request"
+	Message string
+	// WarnDuration logs requests that took at least the specified duration with slog.LevelWarn, 0 disables it
+	WarnDuration time.Duration
+	// ErrorDuration logs requests that took at least the specified duration with slog.LevelError, 0 disables it
+	ErrorDuration time.Duration
+	// Level overrides the level selection
+	Level func(Metrics) slog.Level
+}
+
+// LogFunc returns a MetricsFunc that logs every request with the logger.
+// Unless LogOptions.Level is set, the level is slog.LevelError for 5xx responses, panics and requests slower than
+// ErrorDuration, slog.LevelWarn for 4xx responses and requests slower than WarnDuration and slog.LevelInfo otherwise.
+func LogFunc(logger *slog.Logger, options LogOptions) MetricsFunc {
+	if options.Message == "" {
+		options.Message = "http request"
+	}
+	if options.Level == nil {
+		options.Level = options.level
+	}
+	return func(m Metrics) {
+		ctx := context.Background()
+		if m.Request.Request != nil {
+			ctx = m.Request.Context()
+		}
+		level := options.Level(m)
+		if !logger.Enabled(ctx, level) {
+			return
+		}
+		logger.LogAttrs(ctx, level, options.Message, m.LogValue().Group()...)
+	}
+}
+
+func (options LogOptions) level(m Metrics) slog.Level {
+	switch {
+	case m.Response.Code >= 500, m.Panic != nil,
+		options.ErrorDuration > 0 && m.Duration >= options.ErrorDuration:
+		return slog.LevelError
+	case m.Response.Code >= 400,
+		options.WarnDuration > 0 && m.Duration >= options.WarnDuration:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
@@ -0,0 +1,98 @@
+package httpmetrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+func TestLogValue(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("hello"))
+		}),
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		logger.Info("request", "metrics", m)
+	})
+	collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/path?a=b", strings.NewReader("body")))
+
+	line := buf.String()
+	for _, attr := range []string{
+		"metrics.http.request.method=POST",
+		"metrics.http.request.path=/path",
+		`metrics.http.request.query="a=b"`,
+		"metrics.http.request.route=*",
+		"metrics.http.response.status_code=201",
+		"metrics.http.response.body_bytes=5",
+		"metrics.http.response.complete=true",
+		"metrics.duration=",
+	} {
+		require.Contains(t, line, attr)
+	}
+}
+
+func TestLogFunc(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	fn := httpmetrics.LogFunc(logger, httpmetrics.LogOptions{WarnDuration: time.Second, ErrorDuration: time.Minute})
+
+	tests := []struct {
+		code     int
+		duration time.Duration
+		panic    interface{}
+		level    string
+	}{
+		{http.StatusOK, 0, nil, "INFO"},
+		{http.StatusNotFound, 0, nil, "WARN"},
+		{http.StatusOK, time.Second, nil, "WARN"},
+		{http.StatusInternalServerError, 0, nil, "ERROR"},
+		{http.StatusOK, time.Minute, nil, "ERROR"},
+		{http.StatusOK, 0, "panic", "ERROR"},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		var m httpmetrics.Metrics
+		m.Request.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		m.Response.Code = test.code
+		m.Duration = test.duration
+		m.Panic = test.panic
+		fn(m)
+
+		var entry struct {
+			Level string
+			Msg   string
+			HTTP  struct {
+				Response struct {
+					StatusCode int `json:"status_code"`
+				}
+			}
+			Duration time.Duration
+		}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		require.Equal(t, test.level, entry.Level)
+		require.Equal(t, "http request", entry.Msg)
+		require.Equal(t, test.code, entry.HTTP.Response.StatusCode)
+		require.Equal(t, test.duration, entry.Duration)
+	}
+
+	buf.Reset()
+	fn = httpmetrics.LogFunc(logger, httpmetrics.LogOptions{
+		Message: "custom",
+		Level:   func(httpmetrics.Metrics) slog.Level { return slog.LevelDebug - 1 },
+	})
+	fn(httpmetrics.Metrics{})
+	require.Empty(t, buf.String())
+}
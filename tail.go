@@ -0,0 +1,114 @@
+package httpmetrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TailOptions controls the behavior of the TailSampler
+type TailOptions struct {
+	// Quantile of the request duration of a route above which the bodies are retained, defaults to 0.99
+	Quantile float64
+	// UnusualStatusRate is the share of the responses of a route below which a status code is unusual,
+	// defaults to 0.01
+	UnusualStatusRate float64
+	// MinObservations is the count of requests a route needs before its thresholds are used, defaults to 100.
+	// No bodies are retained for a route until then.
+	MinObservations int
+}
+
+// TailSampler retains the bodies of requests that are slower than the Quantile of their route or have a status
+// code that is unusual for their route. It learns online from the Metrics passed to RetainBodies,
+// the duration quantile is estimated with the P² algorithm.
+type TailSampler struct {
+	options TailOptions
+
+	mu     sync.Mutex
+	routes map[string]*tailRoute
+}
+
+type tailRoute struct {
+	observations int
+	latency      *p2Quantile
+	statusCodes  map[int]int
+}
+
+// TailThreshold holds the current thresholds of a route
+type TailThreshold struct {
+	// Observations is the count of requests seen for the route
+	Observations int
+	// Latency is the current estimate of the Quantile of the request duration
+	Latency time.Duration
+	// UsualStatusCodes are the status codes that are not unusual for the route
+	UsualStatusCodes []int
+}
+
+// NewTailSampler creates a new TailSampler
+func NewTailSampler(options TailOptions) *TailSampler {
+	if options.Quantile <= 0 || options.Quantile >= 1 {
+		options.Quantile = 0.99
+	}
+	if options.UnusualStatusRate <= 0 {
+		options.UnusualStatusRate = 0.01
+	}
+	if options.MinObservations <= 0 {
+		options.MinObservations = 100
+	}
+	return &TailSampler{
+		options: options,
+		routes:  make(map[string]*tailRoute),
+	}
+}
+
+// RetainBodies reports whether the bodies of the request should be retained and records the request,
+// it can be used as CollectOptions.RetainBodies
+func (sampler *TailSampler) RetainBodies(m Metrics) bool {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	route, ok := sampler.routes[m.Route]
+	if !ok {
+		route = &tailRoute{
+			latency:     newP2Quantile(sampler.options.Quantile),
+			statusCodes: make(map[int]int),
+		}
+		sampler.routes[m.Route] = route
+	}
+
+	// judge the request by the thresholds before it was recorded
+	var retain bool
+	if route.observations >= sampler.options.MinObservations {
+		retain = m.Duration.Seconds() > route.latency.value() ||
+			!sampler.usualStatus(route, m.Response.Code)
+	}
+
+	route.observations++
+	route.latency.add(m.Duration.Seconds())
+	route.statusCodes[m.Response.Code]++
+	return retain
+}
+
+func (sampler *TailSampler) usualStatus(route *tailRoute, code int) bool {
+	return float64(route.statusCodes[code]) >= sampler.options.UnusualStatusRate*float64(route.observations)
+}
+
+// Thresholds returns the current thresholds per route
+func (sampler *TailSampler) Thresholds() map[string]TailThreshold {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	thresholds := make(map[string]TailThreshold, len(sampler.routes))
+	for name, route := range sampler.routes {
+		threshold := TailThreshold{
+			Observations: route.observations,
+			Latency:      time.Duration(route.latency.value() * float64(time.Second)),
+		}
+		for code := range route.statusCodes {
+			if sampler.usualStatus(route, code) {
+				threshold.UsualStatusCodes = append(threshold.UsualStatusCodes, code)
+			}
+		}
+		sort.Ints(threshold.UsualStatusCodes)
+		thresholds[name] = threshold
+	}
+	return thresholds
+}
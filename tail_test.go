@@ -0,0 +1,66 @@
+package httpmetrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+func TestTailSampler(t *testing.T) {
+	sampler := httpmetrics.NewTailSampler(httpmetrics.TailOptions{Quantile: 0.9, MinObservations: 10})
+	observe := func(route string, code int, duration time.Duration) bool {
+		var m httpmetrics.Metrics
+		m.Route = route
+		m.Response.Code = code
+		m.Duration = duration
+		return sampler.RetainBodies(m)
+	}
+
+	// nothing is retained while learning
+	for i := 0; i < 10; i++ {
+		require.False(t, observe("/a", http.StatusOK, time.Second))
+	}
+	for i := 0; i < 990; i++ {
+		observe("/a", http.StatusOK, time.Duration(i%100)*time.Millisecond)
+	}
+
+	thresholds := sampler.Thresholds()
+	require.Len(t, thresholds, 1)
+	require.Equal(t, 1000, thresholds["/a"].Observations)
+	require.InDelta(t, 90*time.Millisecond, thresholds["/a"].Latency, float64(10*time.Millisecond))
+	require.Equal(t, []int{http.StatusOK}, thresholds["/a"].UsualStatusCodes)
+
+	require.False(t, observe("/a", http.StatusOK, 10*time.Millisecond))
+	require.True(t, observe("/a", http.StatusOK, 200*time.Millisecond))
+	require.True(t, observe("/a", http.StatusBadGateway, 10*time.Millisecond))
+	// other routes learn separately
+	require.False(t, observe("/b", http.StatusBadGateway, time.Hour))
+}
+
+func TestTailSamplerWithCollector(t *testing.T) {
+	sampler := httpmetrics.NewTailSampler(httpmetrics.TailOptions{MinObservations: 1})
+	var metrics []httpmetrics.Metrics
+	collector := httpmetrics.New(httpmetrics.CollectOptions{
+		Handler: HandleAllRequests(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusTeapot)
+			}
+			w.Write([]byte("body"))
+		}),
+		CollectResponseBody: 1024,
+		RetainBodies:        sampler.RetainBodies,
+	})
+	collector.Collect(func(m httpmetrics.Metrics) {
+		metrics = append(metrics, m.Clone())
+	})
+	for _, path := range []string{"/ok", "/ok", "/fail"} {
+		collector.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	require.Len(t, metrics, 3)
+	require.Nil(t, metrics[0].Response.Body)
+	require.Equal(t, "body", string(metrics[2].Response.Body))
+}
@@ -0,0 +1,303 @@
+package httpmetrics
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/talon-one/go-httpmetrics/internal"
+)
+
+// TransportOptions controls the behavior of the Transport
+type TransportOptions struct {
+	// Base is the http.RoundTripper that sends the requests, defaults to http.DefaultTransport
+	Base http.RoundTripper
+	// CollectResponseBody sets the MaxBufferSize of the Body that should be collected
+	CollectResponseBody int
+	// CollectRequestBody sets the MaxBufferSize of the Body that should be collected
+	CollectRequestBody int
+	// CaptureMode selects whether the head, the tail or both of the bodies are collected
+	CaptureMode CaptureMode
+	// Route sets the Metrics.Route of a request, defaults to the host of the request
+	Route func(*http.Request) string
+	// Redaction masks sensitive headers and body fields before the Metrics are delivered
+	Redaction *Redaction
+}
+
+// ClientTimings holds the connection timings of a request sent by the Transport,
+// the durations are 0 if the step was not necessary, e.g. because a connection was reused
+type ClientTimings struct {
+	// DNS is the duration of the DNS lookup
+	DNS time.Duration
+	// Connect is the duration of establishing the connection
+	Connect time.Duration
+	// TLSHandshake is the duration of the TLS handshake
+	TLSHandshake time.Duration
+	// GotConn is the time from Metrics.Start until a connection was obtained
+	GotConn time.Duration
+	// Reused reports whether the connection was used for a previous request
+	Reused bool
+	// WasIdle reports whether the connection was obtained from the idle pool, IdleTime is for how long it was idle
+	WasIdle  bool
+	IdleTime time.Duration
+}
+
+// Transport is a http.RoundTripper that collects Metrics for outgoing requests.
+//
+// The Metrics are delivered once the response body was read until EOF or closed, or when sending the request failed.
+// Metrics.TimeToHeader is the time until the response header was received,
+// Metrics.TimeToFirstByte the time until the first response byte was received and Response.WrittenBodyBytes the
+// count of response body bytes that were read.
+type Transport struct {
+	fn      MetricsFunc
+	options TransportOptions
+}
+
+// NewTransport creates a new Transport that passes the Metrics of every request to fn
+func NewTransport(fn MetricsFunc, options TransportOptions) *Transport {
+	if options.Base == nil {
+		options.Base = http.DefaultTransport
+	}
+	return &Transport{
+		fn:      fn,
+		options: options,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (transport *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	exchange := &clientExchange{
+		transport: transport,
+	}
+	exchange.metrics.Request.Request = r
+	exchange.metrics.Response.ContentLength = -1
+	if transport.options.Route != nil {
+		exchange.metrics.Route = transport.options.Route(r)
+	} else if r.URL != nil {
+		exchange.metrics.Route = r.URL.Host
+	}
+
+	exchange.metrics.Start = time.Now()
+	req := r.Clone(httptrace.WithClientTrace(r.Context(), exchange.trace()))
+	if r.Body != nil && r.Body != http.NoBody {
+		exchange.requestBody = newBodyRecorder(r.Body, transport.options.CollectRequestBody, transport.options.CaptureMode, nil)
+		req.Body = exchange.requestBody
+	}
+
+	resp, err := transport.options.Base.RoundTrip(req)
+	if err != nil {
+		exchange.metrics.Error = err
+		exchange.finish()
+		return nil, err
+	}
+	exchange.metrics.TimeToHeader = time.Since(exchange.metrics.Start)
+	exchange.metrics.Response.Code = resp.StatusCode
+	exchange.metrics.Response.HeaderWritten = true
+	exchange.metrics.Response.Header = resp.Header
+	exchange.metrics.Response.ContentLength = resp.ContentLength
+
+	if resp.StatusCode == http.StatusSwitchingProtocols || resp.Body == nil {
+		// the body of a protocol switch is the connection, so it cannot be wrapped
+		exchange.finish()
+		return resp, nil
+	}
+	exchange.responseBody = newBodyRecorder(resp.Body, transport.options.CollectResponseBody, transport.options.CaptureMode, exchange.finish)
+	resp.Body = exchange.responseBody
+	return resp, nil
+}
+
+// clientExchange holds the state of a single request sent by the Transport
+type clientExchange struct {
+	transport    *Transport
+	metrics      Metrics
+	requestBody  *bodyRecorder
+	responseBody *bodyRecorder
+
+	mu                                          sync.Mutex
+	timings                                     ClientTimings
+	dnsStart, connectStart, tlsStart, firstByte time.Time
+}
+
+func (exchange *clientExchange) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			exchange.mu.Lock()
+			exchange.dnsStart = time.Now()
+			exchange.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			exchange.mu.Lock()
+			if !exchange.dnsStart.IsZero() {
+				exchange.timings.DNS = time.Since(exchange.dnsStart)
+			}
+			exchange.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			exchange.mu.Lock()
+			// multiple connects can be started for dual stack hosts, the first one starts the measurement
+			if exchange.connectStart.IsZero() {
+				exchange.connectStart = time.Now()
+			}
+			exchange.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			exchange.mu.Lock()
+			if err == nil && exchange.timings.Connect == 0 && !exchange.connectStart.IsZero() {
+				exchange.timings.Connect = time.Since(exchange.connectStart)
+			}
+			exchange.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			exchange.mu.Lock()
+			exchange.tlsStart = time.Now()
+			exchange.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			exchange.mu.Lock()
+			if !exchange.tlsStart.IsZero() {
+				exchange.timings.TLSHandshake = time.Since(exchange.tlsStart)
+			}
+			exchange.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			exchange.mu.Lock()
+			exchange.timings.GotConn = time.Since(exchange.metrics.Start)
+			exchange.timings.Reused = info.Reused
+			exchange.timings.WasIdle = info.WasIdle
+			exchange.timings.IdleTime = info.IdleTime
+			exchange.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			exchange.mu.Lock()
+			exchange.firstByte = time.Now()
+			exchange.mu.Unlock()
+		},
+	}
+}
+
+// finish completes the Metrics and delivers them
+func (exchange *clientExchange) finish() {
+	metrics := exchange.metrics
+	metrics.Duration = time.Since(metrics.Start)
+
+	exchange.mu.Lock()
+	timings := exchange.timings
+	if !exchange.firstByte.IsZero() {
+		metrics.TimeToFirstByte = exchange.firstByte.Sub(metrics.Start)
+	}
+	exchange.mu.Unlock()
+	metrics.Client = &timings
+
+	if exchange.requestBody != nil {
+		body := exchange.requestBody.snapshot()
+		metrics.Request.Body, metrics.Request.ConsumedBodyBytes = body.body, body.read
+		metrics.Request.Truncated = body.truncated
+		metrics.Request.ReadError = body.err
+		metrics.Request.TotalBodyBytes = -1
+		if body.eof {
+			metrics.Request.TotalBodyBytes = body.read
+		}
+	}
+	if exchange.responseBody != nil {
+		body := exchange.responseBody.snapshot()
+		metrics.Response.Body, metrics.Response.WrittenBodyBytes = body.body, body.read
+		metrics.Response.Truncated = body.truncated
+		if body.err != nil {
+			metrics.Error = body.err
+		}
+		metrics.Response.Complete = body.eof && body.err == nil
+		if n := metrics.Response.ContentLength; n >= 0 && int64(metrics.Response.WrittenBodyBytes) != n &&
+			metrics.Request.Method != http.MethodHead {
+			metrics.Response.Complete = false
+		}
+	} else {
+		metrics.Response.Complete = metrics.Error == nil && metrics.Response.HeaderWritten
+	}
+
+	if exchange.transport.options.Redaction != nil {
+		exchange.transport.options.Redaction.apply(&metrics)
+	}
+	exchange.transport.fn(metrics)
+}
+
+// bodyRecorder collects a body stream that might be read and closed from another goroutine
+type bodyRecorder struct {
+	body io.ReadCloser
+	done func()
+	once sync.Once
+
+	mu   sync.Mutex
+	buf  internal.Buffer
+	read int
+	eof  bool
+	err  error
+}
+
+func newBodyRecorder(body io.ReadCloser, maxSize int, mode CaptureMode, done func()) *bodyRecorder {
+	b := &bodyRecorder{
+		body: body,
+		done: done,
+	}
+	b.buf.SetLimit(internal.CaptureMode(mode), maxSize)
+	return b
+}
+
+func (b *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.mu.Lock()
+	if n > 0 {
+		b.read += n
+		_, _ = b.buf.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.eof = true
+	} else if err != nil && b.err == nil {
+		b.err = err
+	}
+	b.mu.Unlock()
+	if err != nil {
+		b.finish()
+	}
+	return n, err
+}
+
+// Close closes the body stream
+func (b *bodyRecorder) Close() error {
+	err := b.body.Close()
+	b.finish()
+	return err
+}
+
+func (b *bodyRecorder) finish() {
+	if b.done != nil {
+		b.once.Do(b.done)
+	}
+}
+
+// recordedBody is a snapshot of a bodyRecorder
+type recordedBody struct {
+	body      []byte
+	read      int
+	eof       bool
+	truncated bool
+	err       error
+}
+
+func (b *bodyRecorder) snapshot() recordedBody {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var body []byte
+	if collected := b.buf.Bytes(); len(collected) > 0 {
+		body = append([]byte(nil), collected...)
+	}
+	return recordedBody{
+		body:      body,
+		read:      b.read,
+		eof:       b.eof,
+		truncated: b.buf.Truncated(),
+		err:       b.err,
+	}
+}
@@ -0,0 +1,161 @@
+package httpmetrics_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/talon-one/go-httpmetrics"
+)
+
+type metricsRecorder struct {
+	mu      sync.Mutex
+	metrics []httpmetrics.Metrics
+}
+
+func (r *metricsRecorder) observe(m httpmetrics.Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *metricsRecorder) get() []httpmetrics.Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]httpmetrics.Metrics(nil), r.metrics...)
+}
+
+func echoServer() *httptest.Server {
+	return httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+}
+
+func TestTransport(t *testing.T) {
+	server := echoServer()
+	server.Start()
+	defer server.Close()
+
+	var recorder metricsRecorder
+	client := &http.Client{Transport: httpmetrics.NewTransport(recorder.observe, httpmetrics.TransportOptions{
+		Base:                &http.Transport{},
+		CollectRequestBody:  4,
+		CollectResponseBody: 1024,
+	})}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(server.URL+"/echo", "text/plain", strings.NewReader("hello world"))
+		require.NoError(t, err)
+		require.Len(t, recorder.get(), i)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "hello world", string(body))
+		require.NoError(t, resp.Body.Close())
+	}
+
+	metrics := recorder.get()
+	require.Len(t, metrics, 2)
+	for i, m := range metrics {
+		require.NoError(t, m.Error)
+		require.Equal(t, strings.TrimPrefix(server.URL, "http://"), m.Route)
+		require.Equal(t, http.MethodPost, m.Request.Method)
+		require.Equal(t, "hell", string(m.Request.Body))
+		require.True(t, m.Request.Truncated)
+		require.Equal(t, 11, m.Request.ConsumedBodyBytes)
+		require.Equal(t, 11, m.Request.TotalBodyBytes)
+		require.Equal(t, http.StatusCreated, m.Response.Code)
+		require.Equal(t, "text/plain", m.Response.Header.Get("Content-Type"))
+		require.Equal(t, "hello world", string(m.Response.Body))
+		require.Equal(t, 11, m.Response.WrittenBodyBytes)
+		require.False(t, m.Response.Truncated)
+		require.Equal(t, int64(11), m.Response.ContentLength)
+		require.True(t, m.Response.Complete)
+		require.True(t, m.TimeToFirstByte > 0)
+		require.True(t, m.TimeToFirstByte <= m.TimeToHeader)
+		require.True(t, m.TimeToHeader <= m.Duration)
+		require.NotNil(t, m.Client)
+		require.True(t, m.Client.GotConn > 0)
+		require.Equal(t, i == 1, m.Client.Reused)
+		require.Equal(t, i == 0, m.Client.Connect > 0)
+		_, ok := m.GetCustomMetric("key")
+		require.False(t, ok)
+	}
+}
+
+func TestTransportTLS(t *testing.T) {
+	server := echoServer()
+	server.StartTLS()
+	defer server.Close()
+
+	var recorder metricsRecorder
+	client := &http.Client{Transport: httpmetrics.NewTransport(recorder.observe, httpmetrics.TransportOptions{
+		Base: server.Client().Transport,
+	})}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	require.NoError(t, err)
+
+	metrics := recorder.get()
+	require.Len(t, metrics, 1)
+	require.True(t, metrics[0].Client.TLSHandshake > 0)
+	require.Nil(t, metrics[0].Response.Body)
+	require.True(t, metrics[0].Response.Complete)
+
+	// closing the body after EOF does not deliver the metrics again
+	require.NoError(t, resp.Body.Close())
+	require.Len(t, recorder.get(), 1)
+}
+
+func TestTransportEarlyClose(t *testing.T) {
+	server := echoServer()
+	server.Start()
+	defer server.Close()
+
+	var recorder metricsRecorder
+	client := &http.Client{Transport: httpmetrics.NewTransport(recorder.observe, httpmetrics.TransportOptions{
+		Base:                &http.Transport{},
+		CollectResponseBody: 1024,
+	})}
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("hello world"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(resp.Body, buf)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	metrics := recorder.get()
+	require.Len(t, metrics, 1)
+	require.Equal(t, "hello", string(metrics[0].Response.Body))
+	require.Equal(t, 5, metrics[0].Response.WrittenBodyBytes)
+	require.False(t, metrics[0].Response.Complete)
+}
+
+func TestTransportError(t *testing.T) {
+	server := echoServer()
+	server.Start()
+	server.Close()
+
+	var recorder metricsRecorder
+	client := &http.Client{Transport: httpmetrics.NewTransport(recorder.observe, httpmetrics.TransportOptions{
+		Base:  &http.Transport{},
+		Route: func(*http.Request) string { return "partner" },
+	})}
+	_, err := client.Get(server.URL)
+	require.Error(t, err)
+
+	metrics := recorder.get()
+	require.Len(t, metrics, 1)
+	require.Error(t, metrics[0].Error)
+	require.Equal(t, "partner", metrics[0].Route)
+	require.Equal(t, 0, metrics[0].Response.Code)
+	require.False(t, metrics[0].Response.Complete)
+}